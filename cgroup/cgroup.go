@@ -0,0 +1,354 @@
+// Package cgroup reads the resource usage of cgroup v2 control groups,
+// which is the natural unit for containers and systemd services.
+package cgroup
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultRoot is where the cgroup v2 hierarchy is usually mounted.
+const DefaultRoot = "/sys/fs/cgroup"
+
+// DefaultProc is where procfs is usually mounted.
+const DefaultProc = "/proc"
+
+// ErrRemoved is returned when a cgroup no longer exists.  It is an
+// fs.ErrNotExist, as errors.Is reports.
+var ErrRemoved = fmt.Errorf("Cgroup removed: %w", fs.ErrNotExist)
+
+// Group is a single cgroup.  Path is relative to Root, as it appears
+// in /proc/<pid>/cgroup.
+type Group struct {
+	Root string
+	Path string
+}
+
+// New returns the cgroup at path under DefaultRoot.
+func New(path string) *Group {
+	return &Group{DefaultRoot, path}
+}
+
+// ForProcess returns the cgroup process belongs to, as found in
+// DefaultProc.
+func ForProcess(process int) (*Group, error) {
+	return ForProcessIn(os.DirFS(DefaultProc), process)
+}
+
+// ForProcessIn returns the cgroup process belongs to, as found in the
+// procfs proc.
+func ForProcessIn(proc fs.FS, process int) (*Group, error) {
+	file, err := proc.Open(strconv.Itoa(process) + "/cgroup")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	path, err := parseProcCgroup(file)
+	if err != nil {
+		return nil, err
+	}
+	return New(path), nil
+}
+
+// Dir is where the group's files are.
+func (g *Group) Dir() string {
+	return filepath.Join(g.Root, g.Path)
+}
+
+func (g *Group) open(name string) (*os.File, error) {
+	return os.Open(filepath.Join(g.Dir(), name))
+}
+
+func parseProcCgroup(in io.Reader) (string, error) {
+	// per cgroups(7) the v2 hierarchy is the line "0::path"; v1
+	// hierarchies have their own lines, which we don't care about.
+	s := bufio.NewScanner(in)
+	for s.Scan() {
+		if strings.HasPrefix(s.Text(), "0::") {
+			return strings.TrimPrefix(s.Text(), "0::"), nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("No cgroup v2 hierarchy; is cgroup v2 mounted?")
+}
+
+// parseFlatKeyed reads files made of "key value" lines, such as
+// cpu.stat and memory.stat.
+func parseFlatKeyed(in io.Reader) (map[string]uint64, error) {
+	result := make(map[string]uint64)
+	s := bufio.NewScanner(in)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Malformed line %q", s.Text())
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		result[fields[0]] = value
+	}
+	return result, s.Err()
+}
+
+// parseSingleValue reads files holding just a number, such as
+// memory.current.  "max" means there is no limit.
+func parseSingleValue(in io.Reader) (uint64, bool, error) {
+	var text string
+	if _, err := fmt.Fscan(in, &text); err != nil {
+		return 0, false, err
+	}
+	if text == "max" {
+		return 0, false, nil
+	}
+	value, err := strconv.ParseUint(text, 10, 64)
+	return value, true, err
+}
+
+// IOStats counts the I/O done by a cgroup, summed across devices.
+type IOStats struct {
+	RBytes uint64
+	WBytes uint64
+	RIOs   uint64
+	WIOs   uint64
+	DBytes uint64
+	DIOs   uint64
+}
+
+func parseIOStat(in io.Reader) (IOStats, error) {
+	// per the kernel's cgroup-v2.rst, each line is a device's
+	// "major:minor" followed by key=value pairs.
+	var stats IOStats
+	keys := map[string]*uint64{
+		"rbytes": &stats.RBytes,
+		"wbytes": &stats.WBytes,
+		"rios":   &stats.RIOs,
+		"wios":   &stats.WIOs,
+		"dbytes": &stats.DBytes,
+		"dios":   &stats.DIOs,
+	}
+	s := bufio.NewScanner(in)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		for _, field := range fields[1:] {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 {
+				return IOStats{}, fmt.Errorf("Malformed io.stat field %q", field)
+			}
+			target, ok := keys[parts[0]]
+			if !ok {
+				continue
+			}
+			value, err := strconv.ParseUint(parts[1], 10, 64)
+			if err != nil {
+				return IOStats{}, err
+			}
+			*target += value
+		}
+	}
+	return stats, s.Err()
+}
+
+// CPUStats is the CPU time used by a cgroup, from cpu.stat.  The
+// throttling counters stay zero unless the group has a quota.
+type CPUStats struct {
+	UsageUsec  uint64
+	UserUsec   uint64
+	SystemUsec uint64
+	// NrPeriods is the number of enforcement periods in which the
+	// group was runnable.
+	NrPeriods uint64
+	// NrThrottled is the number of those periods in which the group
+	// used up its quota and was throttled.
+	NrThrottled uint64
+	// ThrottledUsec is the time the group spent throttled.
+	ThrottledUsec uint64
+}
+
+func parseCPUStat(in io.Reader) (CPUStats, error) {
+	values, err := parseFlatKeyed(in)
+	return CPUStats{
+		values["usage_usec"],
+		values["user_usec"],
+		values["system_usec"],
+		values["nr_periods"],
+		values["nr_throttled"],
+		values["throttled_usec"],
+	}, err
+}
+
+// CPUMax is a cgroup's CPU bandwidth limit, from cpu.max: it may use
+// Quota microseconds of CPU time every Period microseconds.  Quota is
+// zero if the group is unlimited.
+type CPUMax struct {
+	Quota  uint64
+	Period uint64
+}
+
+// CPUs is the limit in CPUs, so 0.5 for half a CPU, or +Inf if the
+// group is unlimited.
+func (c CPUMax) CPUs() float64 {
+	if c.Quota == 0 || c.Period == 0 {
+		return math.Inf(1)
+	}
+	return float64(c.Quota) / float64(c.Period)
+}
+
+func parseCPUMax(in io.Reader) (CPUMax, error) {
+	var quota, period string
+	if _, err := fmt.Fscan(in, &quota, &period); err != nil {
+		return CPUMax{}, err
+	}
+	var max CPUMax
+	var err error
+	if max.Period, err = strconv.ParseUint(period, 10, 64); err != nil {
+		return CPUMax{}, err
+	}
+	if quota == "max" {
+		return max, nil
+	}
+	if max.Quota, err = strconv.ParseUint(quota, 10, 64); err != nil {
+		return CPUMax{}, err
+	}
+	return max, nil
+}
+
+// MemoryEvents counts how often a cgroup ran into its memory limits,
+// from memory.events.
+type MemoryEvents struct {
+	Low     uint64
+	High    uint64
+	Max     uint64
+	OOM     uint64
+	OOMKill uint64
+}
+
+// Stats is everything read from a cgroup at one point in time.  The
+// files for controllers which aren't enabled for the group are
+// missing, leaving their fields zero.
+type Stats struct {
+	CPU    CPUStats
+	CPUMax CPUMax
+	// Memory is memory.current, in bytes
+	Memory uint64
+	// MemoryStat is the breakdown in memory.stat, which varies by
+	// kernel version.
+	MemoryStat   map[string]uint64
+	MemoryEvents MemoryEvents
+	IO           IOStats
+	// PIDs is the number of tasks in the group
+	PIDs uint64
+}
+
+// readFile hands the group's file name to parse, treating a missing
+// file as a disabled controller.
+func (g *Group) readFile(name string, parse func(io.Reader) error) error {
+	file, err := g.open(name)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := parse(file); err != nil {
+		return fmt.Errorf("Couldn't parse %s: %v", file.Name(), err)
+	}
+	return nil
+}
+
+// removed says whether the group's directory has gone.  A missing
+// file only means its controller is disabled while the directory is
+// there, so the check is made again after reading: the files of a
+// group being removed vanish one by one, and would read as zeros.
+func (g *Group) removed() bool {
+	_, err := os.Stat(g.Dir())
+	return os.IsNotExist(err)
+}
+
+// CPU reads just the group's CPU usage and limit, which is cheaper
+// than Stats.  The root cgroup has no cpu.max, so is unlimited.
+func (g *Group) CPU() (CPUStats, CPUMax, error) {
+	var stats CPUStats
+	var max CPUMax
+	if g.removed() {
+		return stats, max, ErrRemoved
+	}
+	err := g.readFile("cpu.stat", func(in io.Reader) (err error) {
+		stats, err = parseCPUStat(in)
+		return err
+	})
+	if err != nil {
+		return stats, max, err
+	}
+	err = g.readFile("cpu.max", func(in io.Reader) (err error) {
+		max, err = parseCPUMax(in)
+		return err
+	})
+	if err == nil && g.removed() {
+		return CPUStats{}, CPUMax{}, ErrRemoved
+	}
+	return stats, max, err
+}
+
+// Stats reads the group's current resource usage.
+func (g *Group) Stats() (*Stats, error) {
+	if g.removed() {
+		return nil, ErrRemoved
+	}
+	s := new(Stats)
+	files := []struct {
+		name  string
+		parse func(io.Reader) error
+	}{
+		{"cpu.stat", func(in io.Reader) (err error) {
+			s.CPU, err = parseCPUStat(in)
+			return err
+		}},
+		{"cpu.max", func(in io.Reader) (err error) {
+			s.CPUMax, err = parseCPUMax(in)
+			return err
+		}},
+		{"memory.current", func(in io.Reader) (err error) {
+			s.Memory, _, err = parseSingleValue(in)
+			return err
+		}},
+		{"memory.stat", func(in io.Reader) (err error) {
+			s.MemoryStat, err = parseFlatKeyed(in)
+			return err
+		}},
+		{"memory.events", func(in io.Reader) error {
+			values, err := parseFlatKeyed(in)
+			s.MemoryEvents = MemoryEvents{values["low"], values["high"], values["max"], values["oom"], values["oom_kill"]}
+			return err
+		}},
+		{"io.stat", func(in io.Reader) (err error) {
+			s.IO, err = parseIOStat(in)
+			return err
+		}},
+		{"pids.current", func(in io.Reader) (err error) {
+			s.PIDs, _, err = parseSingleValue(in)
+			return err
+		}},
+	}
+	for _, file := range files {
+		if err := g.readFile(file.name, file.parse); err != nil {
+			return nil, err
+		}
+	}
+	if g.removed() {
+		return nil, ErrRemoved
+	}
+	return s, nil
+}
@@ -0,0 +1,210 @@
+package cgroup
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeGroup builds a cgroupfs-like directory holding files.
+func fakeGroup(t *testing.T, files map[string]string) (*Group, func()) {
+	root, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	group := &Group{root, "/system.slice/test.service"}
+	if err := os.MkdirAll(group.Dir(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, group, files)
+	return group, func() { os.RemoveAll(root) }
+}
+
+func writeFiles(t *testing.T, group *Group, files map[string]string) {
+	for name, contents := range files {
+		if err := ioutil.WriteFile(filepath.Join(group.Dir(), name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+var sampleFiles = map[string]string{
+	"cpu.stat": `usage_usec 1500000
+user_usec 1000000
+system_usec 500000
+nr_periods 40
+nr_throttled 4
+throttled_usec 20000
+`,
+	"cpu.max":        "50000 100000\n",
+	"memory.current": "104857600\n",
+	"memory.stat": `anon 52428800
+file 41943040
+kernel_stack 163840
+`,
+	"memory.events": `low 0
+high 2
+max 1
+oom 0
+oom_kill 0
+`,
+	"io.stat": `8:0 rbytes=4096 wbytes=8192 rios=1 wios=2 dbytes=0 dios=0
+8:16 rbytes=4096 wbytes=0 rios=1 wios=0 dbytes=0 dios=0
+`,
+	"pids.current": "7\n",
+}
+
+func TestProcCgroup(t *testing.T) {
+	path, err := parseProcCgroup(strings.NewReader(`0::/system.slice/nginx.service
+`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "/system.slice/nginx.service", path)
+	}
+	path, err = parseProcCgroup(strings.NewReader(`12:pids:/system.slice/nginx.service
+1:name=systemd:/system.slice/nginx.service
+0::/system.slice/nginx.service
+`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "/system.slice/nginx.service", path)
+	}
+	_, err = parseProcCgroup(strings.NewReader(`12:pids:/system.slice/nginx.service
+`))
+	assert.Error(t, err)
+}
+
+func TestCPUMax(t *testing.T) {
+	max, err := parseCPUMax(strings.NewReader("max 100000\n"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, CPUMax{0, 100000}, max)
+		assert.True(t, math.IsInf(max.CPUs(), 1))
+	}
+	max, err = parseCPUMax(strings.NewReader("150000 100000\n"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1.5, max.CPUs())
+	}
+	_, err = parseCPUMax(strings.NewReader("max\n"))
+	assert.Error(t, err)
+	_, err = parseCPUMax(strings.NewReader("lots 100000\n"))
+	assert.Error(t, err)
+}
+
+func TestCPU(t *testing.T) {
+	group, cleanup := fakeGroup(t, sampleFiles)
+	defer cleanup()
+	stats, max, err := group.CPU()
+	if assert.NoError(t, err) {
+		assert.Equal(t, CPUStats{1500000, 1000000, 500000, 40, 4, 20000}, stats)
+		assert.Equal(t, CPUMax{50000, 100000}, max)
+	}
+	os.Remove(filepath.Join(group.Dir(), "cpu.max"))
+	_, max, err = group.CPU()
+	if assert.NoError(t, err) {
+		assert.Equal(t, CPUMax{}, max)
+	}
+	os.RemoveAll(group.Dir())
+	_, _, err = group.CPU()
+	assert.Equal(t, ErrRemoved, err)
+}
+
+func TestStats(t *testing.T) {
+	group, cleanup := fakeGroup(t, sampleFiles)
+	defer cleanup()
+	stats, err := group.Stats()
+	if assert.NoError(t, err) {
+		assert.Equal(t, &Stats{
+			CPU:          CPUStats{1500000, 1000000, 500000, 40, 4, 20000},
+			CPUMax:       CPUMax{50000, 100000},
+			Memory:       104857600,
+			MemoryStat:   map[string]uint64{"anon": 52428800, "file": 41943040, "kernel_stack": 163840},
+			MemoryEvents: MemoryEvents{0, 2, 1, 0, 0},
+			IO:           IOStats{8192, 8192, 2, 2, 0, 0},
+			PIDs:         7,
+		}, stats)
+	}
+}
+
+func TestStatsMissingControllers(t *testing.T) {
+	group, cleanup := fakeGroup(t, map[string]string{"pids.current": "3\n"})
+	defer cleanup()
+	stats, err := group.Stats()
+	if assert.NoError(t, err) {
+		assert.Equal(t, &Stats{PIDs: 3}, stats)
+	}
+}
+
+func TestBrokenStats(t *testing.T) {
+	group, cleanup := fakeGroup(t, map[string]string{"cpu.stat": "usage_usec lots\n"})
+	defer cleanup()
+	_, err := group.Stats()
+	assert.Error(t, err)
+	writeFiles(t, group, map[string]string{"cpu.stat": "", "io.stat": "8:0 rbytes\n"})
+	_, err = group.Stats()
+	assert.Error(t, err)
+	_, err = (&Group{group.Root, "/nonexistent"}).Stats()
+	assert.Equal(t, ErrRemoved, err)
+	assert.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestMonitor(t *testing.T) {
+	group, cleanup := fakeGroup(t, sampleFiles)
+	defer cleanup()
+	out := make(chan Measure, 1)
+	m, err := NewMonitor(context.Background(), out, group, Options{Interval: 5 * time.Millisecond})
+	if !assert.NoError(t, err) {
+		return
+	}
+	writeFiles(t, group, map[string]string{
+		"cpu.stat":      "usage_usec 2500000\nuser_usec 1800000\nsystem_usec 700000\nnr_periods 60\nnr_throttled 14\nthrottled_usec 90000\n",
+		"memory.events": "low 0\nhigh 5\nmax 1\noom 1\noom_kill 1\n",
+	})
+	var measure Measure
+	for measure.CPU.UsageUsec == 0 {
+		measure = <-out
+	}
+	assert.Equal(t, CPUStats{1000000, 800000, 200000, 20, 10, 70000}, measure.CPU)
+	assert.Equal(t, 50.0, measure.ThrottledPerc())
+	assert.Equal(t, 2*measure.CPUPerc(), measure.QuotaPerc())
+	assert.Equal(t, MemoryEvents{0, 3, 0, 1, 1}, measure.MemoryEvents)
+	assert.Equal(t, IOStats{}, measure.IO)
+	assert.Equal(t, uint64(7), measure.PIDs)
+
+	os.RemoveAll(group.Dir())
+	for range out {
+	}
+	// known as soon as Output is closed
+	assert.Equal(t, ErrRemoved, m.Err())
+	assert.Equal(t, ErrRemoved, m.Wait())
+	m.Stop()
+}
+
+func TestMonitorInterval(t *testing.T) {
+	group, cleanup := fakeGroup(t, sampleFiles)
+	defer cleanup()
+	_, err := NewMonitor(context.Background(), make(chan Measure), group, Options{Interval: -time.Second})
+	assert.Error(t, err)
+	m, err := NewMonitor(context.Background(), make(chan Measure), group, Options{})
+	if assert.NoError(t, err) {
+		m.Stop()
+	}
+}
+
+func TestMeasureBackwards(t *testing.T) {
+	// a controller disabled between samples reads as zeros
+	old := &Stats{
+		CPU:          CPUStats{1500000, 1000000, 500000, 40, 4, 20000},
+		MemoryEvents: MemoryEvents{0, 2, 1, 0, 0},
+		IO:           IOStats{8192, 8192, 2, 2, 0, 0},
+	}
+	new := &Stats{CPU: CPUStats{1600000, 1100000, 500000, 42, 4, 20000}}
+	measure := measure(old, new, time.Second)
+	assert.Equal(t, CPUStats{100000, 100000, 0, 2, 0, 0}, measure.CPU)
+	assert.Equal(t, MemoryEvents{}, measure.MemoryEvents)
+	assert.Equal(t, IOStats{}, measure.IO)
+}
@@ -0,0 +1,205 @@
+package cgroup
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"time"
+)
+
+// Measure is a cgroup's resource consumption over a sampling
+// interval.  Counters are deltas over the interval; Memory,
+// MemoryStat and PIDs are as of its end.
+type Measure struct {
+	Elapsed      time.Duration
+	CPU          CPUStats
+	CPUMax       CPUMax
+	Memory       uint64
+	MemoryStat   map[string]uint64
+	MemoryEvents MemoryEvents
+	IO           IOStats
+	PIDs         uint64
+}
+
+// CPUPerc calculates the CPU time used as a percentage of one CPU, so
+// a group keeping two CPUs busy is at 200.
+func (m *Measure) CPUPerc() float64 {
+	return 100.0 * float64(m.CPU.UsageUsec) / float64(m.Elapsed.Microseconds())
+}
+
+// QuotaPerc calculates the CPU time used as a percentage of the
+// group's quota, which is 0 if it is unlimited.
+func (m *Measure) QuotaPerc() float64 {
+	return m.CPUPerc() / m.CPUMax.CPUs()
+}
+
+// ThrottledPerc calculates the percentage of enforcement periods in
+// which the group was throttled, or NaN if it has no quota or was
+// never runnable.
+func (m *Measure) ThrottledPerc() float64 {
+	if m.CPU.NrPeriods == 0 {
+		return math.NaN()
+	}
+	return 100.0 * float64(m.CPU.NrThrottled) / float64(m.CPU.NrPeriods)
+}
+
+// since is how much a counter grew from then to now.  Counters only
+// go backwards when a controller is disabled or a device goes away,
+// which is no growth rather than a wrap to nearly 2^64.
+func since(now, then uint64) uint64 {
+	if now < then {
+		return 0
+	}
+	return now - then
+}
+
+func measure(old, new *Stats, elapsed time.Duration) Measure {
+	return Measure{
+		Elapsed: elapsed,
+		CPU: CPUStats{
+			since(new.CPU.UsageUsec, old.CPU.UsageUsec),
+			since(new.CPU.UserUsec, old.CPU.UserUsec),
+			since(new.CPU.SystemUsec, old.CPU.SystemUsec),
+			since(new.CPU.NrPeriods, old.CPU.NrPeriods),
+			since(new.CPU.NrThrottled, old.CPU.NrThrottled),
+			since(new.CPU.ThrottledUsec, old.CPU.ThrottledUsec),
+		},
+		CPUMax:     new.CPUMax,
+		Memory:     new.Memory,
+		MemoryStat: new.MemoryStat,
+		MemoryEvents: MemoryEvents{
+			since(new.MemoryEvents.Low, old.MemoryEvents.Low),
+			since(new.MemoryEvents.High, old.MemoryEvents.High),
+			since(new.MemoryEvents.Max, old.MemoryEvents.Max),
+			since(new.MemoryEvents.OOM, old.MemoryEvents.OOM),
+			since(new.MemoryEvents.OOMKill, old.MemoryEvents.OOMKill),
+		},
+		IO: IOStats{
+			since(new.IO.RBytes, old.IO.RBytes),
+			since(new.IO.WBytes, old.IO.WBytes),
+			since(new.IO.RIOs, old.IO.RIOs),
+			since(new.IO.WIOs, old.IO.WIOs),
+			since(new.IO.DBytes, old.IO.DBytes),
+			since(new.IO.DIOs, old.IO.DIOs),
+		},
+		PIDs: new.PIDs,
+	}
+}
+
+// DefaultInterval is how often a Monitor samples unless told
+// otherwise.
+const DefaultInterval = 5 * time.Second
+
+// Options configure a Monitor.  The zero value samples every
+// DefaultInterval and logs to the logrus standard logger.
+type Options struct {
+	// Interval is the time between samples; zero means
+	// DefaultInterval.
+	Interval time.Duration
+	// Logger receives the monitor's diagnostics; nil means the
+	// logrus standard logger.
+	Logger log.FieldLogger
+}
+
+// Monitor represents a continuous monitoring of a cgroup.  Output is
+// closed once monitoring ends, whether because of Stop, cancellation
+// of its context, or an error; Err then says which.
+type Monitor struct {
+	Output chan<- Measure
+	group  *Group
+	ticker *time.Ticker
+	logger *log.Entry
+	cancel context.CancelFunc
+	done   chan struct{}
+	// finished is closed once err is set, before Output is closed
+	finished chan struct{}
+	err      error
+}
+
+// NewMonitor starts monitoring group as opts say, sending a measure to
+// out every interval and dropping it if out is full.  It stops when
+// ctx is done.
+func NewMonitor(ctx context.Context, out chan<- Measure, group *Group, opts Options) (*Monitor, error) {
+	if opts.Interval < 0 {
+		return nil, fmt.Errorf("Negative sampling interval %v", opts.Interval)
+	}
+	if opts.Interval == 0 {
+		opts.Interval = DefaultInterval
+	}
+	if opts.Logger == nil {
+		opts.Logger = log.StandardLogger()
+	}
+	stats, err := group.Stats()
+	if err != nil {
+		return nil, err
+	}
+	m := &Monitor{
+		Output:   out,
+		group:    group,
+		ticker:   time.NewTicker(opts.Interval),
+		logger:   opts.Logger.WithField("cgroup", group.Path),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	ctx, m.cancel = context.WithCancel(ctx)
+	go m.run(ctx, stats, time.Now())
+	return m, nil
+}
+
+func (m *Monitor) run(ctx context.Context, last *Stats, at time.Time) {
+	defer close(m.done)
+	defer close(m.Output)
+	defer m.ticker.Stop()
+	m.err = m.loop(ctx, last, at)
+	close(m.finished)
+}
+
+func (m *Monitor) loop(ctx context.Context, last *Stats, at time.Time) error {
+	for {
+		select {
+		case <-m.ticker.C:
+			stats, err := m.group.Stats()
+			if err != nil {
+				m.logger.WithError(err).Error("couldn't read cgroup stats")
+				return err
+			}
+			now := time.Now()
+			select {
+			case m.Output <- measure(last, stats, now.Sub(at)):
+			default:
+				m.logger.Warn("Output full, dropping update")
+			}
+			last, at = stats, now
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Stop halts monitoring and waits for it to finish, after which
+// Output is closed.  It is safe to call more than once.
+func (m *Monitor) Stop() {
+	m.cancel()
+	<-m.done
+}
+
+// Wait blocks until monitoring ends and returns the reason, as Err
+// does.
+func (m *Monitor) Wait() error {
+	<-m.done
+	return m.err
+}
+
+// Err returns nil while monitoring is running.  Once it has ended, it
+// returns context.Canceled if Stop was called, the context's error if
+// it was done, ErrRemoved if the cgroup went away, or whatever
+// prevented reading it.
+func (m *Monitor) Err() error {
+	select {
+	case <-m.finished:
+		return m.err
+	default:
+		return nil
+	}
+}
@@ -1,24 +1,55 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/meteor/procmon"
 	"github.com/meteor/procmon/ecu"
+	"github.com/meteor/procmon/statsd"
+	"os"
+	"regexp"
 	"strconv"
+	"strings"
 )
 
+var interval = flag.Duration("interval", procmon.DefaultInterval, "time between samples")
+var tree = flag.Bool("tree", false, "include all descendants of the process")
+var threads = flag.Bool("threads", false, "break CPU usage down by thread")
+var detailedMemory = flag.Bool("detailed-memory", false, "read PSS, USS and swap usage")
+var quota = flag.Bool("quota", false, "report CPU use against the quota of the process's cgroup")
+var pressure = flag.String("pressure", "", "read pressure stall information for the \"host\" or the process's \"cgroup\"")
+var host = flag.Bool("host", false, "read the memory, load and paging activity of the whole system")
+var procRoot = flag.String("proc", "/proc", "where procfs is mounted, e.g. /host/proc in a container")
+var kernel = flag.Bool("kernel", false, "read the kernel log to tell whether the process was killed by OOM or for a segfault")
+var comm = flag.String("comm", "", "follow the processes with this name instead of a pid")
+var cmdline = flag.String("cmdline", "", "follow the processes whose command lines match this regexp")
+var pidfile = flag.String("pidfile", "", "follow the process whose pid is in this file")
+var unit = flag.String("unit", "", "follow the processes of this systemd unit")
+var top = flag.Int("top", 0, "print the top processes by CPU over one interval and exit")
+var statsdAddr = flag.String("statsd", "", "DogStatsD address to send measures to, e.g. "+statsd.DefaultAddr)
+var statsdPrefix = flag.String("prefix", "procmon.", "prefix for statsd metric names")
+var statsdTags = flag.String("tags", "", "comma separated tags to attach to statsd metrics")
+
 func main() {
 	flag.Parse()
 
-	if flag.NArg() < 1 {
-		fmt.Println("Input process is missing")
-	}
-
-	process, err := strconv.ParseInt(flag.Arg(0), 10, 32)
-	if err != nil {
-		log.WithField("input", flag.Arg(0)).WithError(err).Fatal("Couldn't parse input process")
+	if *top > 0 {
+		table, err := procmon.Snapshot(os.DirFS(*procRoot), *interval)
+		if err != nil {
+			log.WithError(err).Fatal("Couldn't scan processes")
+		}
+		table.SortByCPU()
+		for _, row := range table.Top(*top) {
+			command := row.Cmdline
+			if command == "" {
+				// kernel threads have no command line
+				command = "[" + row.Comm + "]"
+			}
+			fmt.Printf("%7d %-10s %6.2f%% %10d %4d %s\n", row.PID, row.User, row.CPUPerc, row.RSS/1024, row.Threads, command)
+		}
+		return
 	}
 
 	instance, err := ecu.Mine()
@@ -26,28 +57,169 @@ func main() {
 		log.WithError(err).Error("Couldn't find instance metadata")
 	}
 
+	var selector procmon.Selector
+	switch {
+	case *comm != "":
+		selector = procmon.ByComm(*comm)
+	case *cmdline != "":
+		pattern, err := regexp.Compile(*cmdline)
+		if err != nil {
+			log.WithError(err).Fatal("Couldn't parse command line pattern")
+		}
+		selector = procmon.ByCmdline(pattern)
+	case *pidfile != "":
+		selector = procmon.ByPidfile(*pidfile)
+	case *unit != "":
+		selector = procmon.BySystemdUnit(*unit)
+	}
+
+	var scope procmon.PressureScope
+	switch *pressure {
+	case "":
+	case "host":
+		scope = procmon.HostPressure
+	case "cgroup":
+		scope = procmon.CgroupPressure
+	default:
+		log.WithField("pressure", *pressure).Fatal("Pressure must be host or cgroup")
+	}
+
+	var client *statsd.Client
+	if *statsdAddr != "" {
+		var clientTags []string
+		if *statsdTags != "" {
+			clientTags = strings.Split(*statsdTags, ",")
+		}
+		client, err = statsd.New(*statsdAddr, *statsdPrefix, clientTags...)
+		if err != nil {
+			log.WithError(err).Fatal("Couldn't set up statsd client")
+		}
+		defer client.Close()
+	}
+
+	options := procmon.Options{Interval: *interval, Tree: *tree, Threads: *threads, DetailedMemory: *detailedMemory, Quota: *quota, Pressure: scope, Host: *host, Proc: os.DirFS(*procRoot), Kernel: *kernel}
 	output := make(chan procmon.Measure, 1)
-	monitor, err := procmon.New(output, int(process))
-	if err != nil {
-		log.WithError(err).Fatal("Couldn't parse input process")
+	// measures are tagged with their pid as they're sent, but the
+	// exit of the process given by pid needs it spelled out
+	var exitTags []string
+	var reason func() error
+	exit := func() *procmon.Exit { return nil }
+	if selector != nil {
+		// each process followed reports its own exit, as it goes
+		options.OnExit = func(pid int, exit *procmon.Exit) {
+			log.WithField("pid", pid).WithField("cause", exit.String()).Info("Process went away")
+			if client != nil {
+				reportExit(client, exit, fmt.Sprintf("pid:%d", pid))
+			}
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		result := make(chan error, 1)
+		go func() { result <- procmon.Follow(ctx, output, selector, options) }()
+		reason = func() error { return <-result }
+	} else {
+		if flag.NArg() < 1 {
+			fmt.Println("Input process is missing")
+		}
+
+		process, err := strconv.ParseInt(flag.Arg(0), 10, 32)
+		if err != nil {
+			log.WithField("input", flag.Arg(0)).WithError(err).Fatal("Couldn't parse input process")
+		}
+
+		monitor, err := procmon.NewWithOptions(output, int(process), options)
+		if err != nil {
+			log.WithError(err).Fatal("Couldn't parse input process")
+		}
+		defer monitor.Stop()
+		exitTags = append(exitTags, fmt.Sprintf("pid:%d", process))
+		// wait for the monitor to be done with the process before
+		// asking why it stopped, and then how the process exited
+		reason = monitor.Wait
+		exit = monitor.Exit
+	}
+
+	if client != nil {
+		client.Forward(output, instance)
+		ended(reason(), exit())
+		if e := exit(); e != nil {
+			reportExit(client, e, exitTags...)
+		}
+		return
 	}
-	defer monitor.Stop()
 
 outerloop:
 	for {
 		select {
 		case point, ok := <-output:
 			if !ok {
-				log.Warn("Not ok, breaking")
+				ended(reason(), exit())
 				break outerloop
 			}
 			log.WithFields(log.Fields{
+				"pid":        point.PID,
 				"user":       point.UserPerc(),
 				"system":     point.SysPerc(),
+				"iowait":     point.IOWaitPerc(),
+				"steal":      point.StealPerc(),
+				"processor":  point.Processor,
+				"coreBusy":   point.ProcessorBusyPerc(),
+				"quota":      point.QuotaPerc(),
+				"throttled":  point.ThrottledPerc(),
 				"userInECU":  point.UserInECU(instance),
 				"sysInECU":   point.SysInECU(instance),
 				"memoryInKB": point.Memory,
+				"memoryPerc": point.MemoryPerc(),
+				"vsz":        point.MemoryStats.VSZ,
+				"pss":        point.MemoryStats.PSS,
+				"uss":        point.MemoryStats.USS,
+				"swap":       point.MemoryStats.Swap,
+				"readRate":   point.ReadRate(),
+				"writeRate":  point.WriteRate(),
 			}).Debug("Got point")
+			if h := point.Host; h != nil {
+				log.WithFields(log.Fields{
+					"available":   h.Memory.Available,
+					"dirty":       h.Memory.Dirty,
+					"load1":       h.Load.Load1,
+					"majorFaults": h.MajorFaults,
+					"oomKills":    h.OOMKills,
+				}).Debug("Got host")
+			}
+			if p := point.Pressure; p != nil {
+				log.WithFields(log.Fields{
+					"cpuSome":    p.CPU.Some.Avg10,
+					"memorySome": p.Memory.Some.Avg10,
+					"memoryFull": p.Memory.Full.Avg10,
+					"ioSome":     p.IO.Some.Avg10,
+					"ioFull":     p.IO.Full.Avg10,
+				}).Debug("Got pressure")
+			}
+			for _, thread := range point.Threads {
+				log.WithFields(log.Fields{
+					"tid":    thread.TID,
+					"comm":   thread.Comm,
+					"user":   thread.User,
+					"system": thread.System,
+				}).Debug("Got thread")
+			}
 		}
 	}
 }
+
+// reportExit sends how a process went away to statsd.
+func reportExit(client *statsd.Client, exit *procmon.Exit, tags ...string) {
+	if err := client.ReportExit(exit, tags...); err != nil {
+		log.WithError(err).Warn("couldn't send exit to statsd")
+	}
+}
+
+// ended logs why monitoring ended, with how the process went away if
+// it's known.
+func ended(err error, exit *procmon.Exit) {
+	entry := log.WithError(err)
+	if exit != nil {
+		entry = entry.WithField("cause", exit.String())
+	}
+	entry.Warn("Monitoring ended")
+}
@@ -0,0 +1,164 @@
+// Package clock lets monitors be driven by a fake clock in tests,
+// rather than waiting on real timers.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and makes tickers and timers, as the time
+// package does.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	After(d time.Duration) <-chan time.Time
+}
+
+// Ticker delivers ticks at intervals, as a time.Ticker does.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+// Real is the system clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
+
+// Fake is a clock which only moves when told to.  Like real ones, its
+// tickers drop ticks for slow receivers.
+type Fake struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+// A ticker or timer waiting for the fake clock to reach next
+type waiter struct {
+	clock  *Fake
+	c      chan time.Time
+	next   time.Time
+	period time.Duration
+}
+
+// NewFake creates a fake clock reading now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the fake time.
+func (f *Fake) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+// NewTicker creates a ticker which ticks every time the clock is
+// advanced past another multiple of d from now.
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return f.wait(d, d)
+}
+
+// After returns a channel which receives the time once the clock is
+// advanced by d.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.wait(d, 0).c
+}
+
+func (f *Fake) wait(d, period time.Duration) *waiter {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	w := &waiter{f, make(chan time.Time, 1), f.now.Add(d), period}
+	f.waiters = append(f.waiters, w)
+	return w
+}
+
+// Advance moves the clock forward by d, firing the tickers and timers
+// which come due in order.  The receivers run concurrently, so may not
+// have acted on the ticks by the time Advance returns.
+func (f *Fake) Advance(d time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	end := f.now.Add(d)
+	for {
+		sort.SliceStable(f.waiters, func(i, j int) bool {
+			return f.waiters[i].next.Before(f.waiters[j].next)
+		})
+		if len(f.waiters) == 0 || f.waiters[0].next.After(end) {
+			break
+		}
+		w := f.waiters[0]
+		f.now = w.next
+		select {
+		case w.c <- f.now:
+		default:
+		}
+		if w.period == 0 {
+			f.waiters = f.waiters[1:]
+		} else {
+			w.next = w.next.Add(w.period)
+		}
+	}
+	f.now = end
+}
+
+// Waiters is the number of tickers and timers which have yet to fire,
+// or to be stopped.
+func (f *Fake) Waiters() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.waiters)
+}
+
+func (w *waiter) C() <-chan time.Time {
+	return w.c
+}
+
+func (w *waiter) Stop() {
+	w.clock.lock.Lock()
+	defer w.clock.lock.Unlock()
+	for i, other := range w.clock.waiters {
+		if other == w {
+			w.clock.waiters = append(w.clock.waiters[:i], w.clock.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+func (w *waiter) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("non-positive interval for Ticker.Reset")
+	}
+	w.Stop()
+	w.clock.lock.Lock()
+	defer w.clock.lock.Unlock()
+	w.next = w.clock.now.Add(d)
+	w.period = d
+	w.clock.waiters = append(w.clock.waiters, w)
+}
@@ -0,0 +1,65 @@
+package clock
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFakeTicker(t *testing.T) {
+	c := NewFake(epoch)
+	ticker := c.NewTicker(time.Second)
+	c.Advance(999 * time.Millisecond)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked early")
+	default:
+	}
+	c.Advance(time.Millisecond)
+	assert.Equal(t, epoch.Add(time.Second), <-ticker.C())
+
+	// a slow receiver misses ticks
+	c.Advance(3 * time.Second)
+	assert.Equal(t, epoch.Add(2*time.Second), <-ticker.C())
+	assert.Equal(t, epoch.Add(4*time.Second), c.Now())
+
+	ticker.Reset(10 * time.Second)
+	c.Advance(9 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked before reset interval")
+	default:
+	}
+	c.Advance(time.Second)
+	assert.Equal(t, epoch.Add(14*time.Second), <-ticker.C())
+
+	ticker.Stop()
+	assert.Equal(t, 0, c.Waiters())
+	c.Advance(time.Minute)
+	select {
+	case <-ticker.C():
+		t.Fatal("ticked after stop")
+	default:
+	}
+}
+
+func TestFakeAfter(t *testing.T) {
+	c := NewFake(epoch)
+	after := c.After(time.Second)
+	ticker := c.NewTicker(300 * time.Millisecond)
+	assert.Equal(t, 2, c.Waiters())
+	c.Advance(time.Second)
+	assert.Equal(t, epoch.Add(time.Second), <-after)
+	assert.Equal(t, epoch.Add(300*time.Millisecond), <-ticker.C())
+	assert.Equal(t, 1, c.Waiters())
+}
+
+func TestReal(t *testing.T) {
+	ticker := Real.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	<-ticker.C()
+	<-Real.After(time.Millisecond)
+	assert.False(t, Real.Now().IsZero())
+}
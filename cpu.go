@@ -0,0 +1,93 @@
+package procmon
+
+import "sort"
+
+// CPUTimes is the time spent by the CPUs in each state, in jiffies,
+// as reported by the cpu lines of /proc/stat.  Fields the kernel is
+// too old to report are zero.
+type CPUTimes struct {
+	// User is time spent in usermode, excluding Nice
+	User uint64
+	// Nice is time spent in usermode at low priority
+	Nice uint64
+	// System is time spent in kernelmode
+	System uint64
+	// Idle is time spent in the idle task
+	Idle uint64
+	// IOWait is time spent idle while waiting for I/O to complete
+	IOWait uint64
+	// IRQ is time spent servicing interrupts
+	IRQ uint64
+	// SoftIRQ is time spent servicing softirqs
+	SoftIRQ uint64
+	// Steal is time a hypervisor gave to other virtual machines
+	Steal uint64
+	// Guest is time spent running virtual CPUs for guests.  It is
+	// also counted in User.
+	Guest uint64
+	// GuestNice is time spent running low priority virtual CPUs for
+	// guests.  It is also counted in Nice.
+	GuestNice uint64
+}
+
+func (c *CPUTimes) sub(other CPUTimes) CPUTimes {
+	return CPUTimes{
+		since(c.User, other.User),
+		since(c.Nice, other.Nice),
+		since(c.System, other.System),
+		since(c.Idle, other.Idle),
+		since(c.IOWait, other.IOWait),
+		since(c.IRQ, other.IRQ),
+		since(c.SoftIRQ, other.SoftIRQ),
+		since(c.Steal, other.Steal),
+		since(c.Guest, other.Guest),
+		since(c.GuestNice, other.GuestNice),
+	}
+}
+
+// since is how far a counter has moved from then to now, or zero if
+// it went backwards, as proc(5) warns iowait can.
+func since(now, then uint64) uint64 {
+	if now < then {
+		return 0
+	}
+	return now - then
+}
+
+func (c *CPUTimes) total() uint64 {
+	// guest time is already counted in User and Nice
+	return c.User + c.Nice + c.System + c.Idle + c.IOWait +
+		c.IRQ + c.SoftIRQ + c.Steal
+}
+
+// CoreMeasure is what a single CPU spent its time on over a sampling
+// interval.
+type CoreMeasure struct {
+	// CPU is the CPU's number, as in cpuN in /proc/stat.
+	CPU int
+	// Times breaks the interval down by state.
+	Times CPUTimes
+}
+
+// BusyPerc calculates the percentage of the interval the CPU was
+// doing anything other than idling or waiting for I/O.
+func (c *CoreMeasure) BusyPerc() float64 {
+	total := c.Times.total()
+	return 100.0 * float64(total-c.Times.Idle-c.Times.IOWait) / float64(total)
+}
+
+// coreDelta works out what each CPU did between two samples, ordered
+// by CPU number.  CPUs are hotpluggable, so only those online for
+// both samples are included.
+func coreDelta(old, new map[int]CPUTimes) []CoreMeasure {
+	var result []CoreMeasure
+	for cpu, now := range new {
+		then, ok := old[cpu]
+		if !ok {
+			continue
+		}
+		result = append(result, CoreMeasure{cpu, now.sub(then)})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CPU < result[j].CPU })
+	return result
+}
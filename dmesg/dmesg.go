@@ -8,6 +8,30 @@ type Message struct {
 	Level     int64
 	Timestamp time.Time
 	Message   string
+
+	// The remaining fields are only known for messages read from
+	// /dev/kmsg.
+
+	// Facility is the syslog facility, which is 0 (kern) for the
+	// kernel's own messages.
+	Facility int64
+	// Sequence numbers messages in the order the kernel logged them,
+	// with no gaps.
+	Sequence uint64
+	// Fragment is set if the kernel flagged the message as part of a
+	// line which later messages continue.
+	Fragment bool
+	// Subsystem is the subsystem of the device the message is about,
+	// such as "pci" or "net", if any.
+	Subsystem string
+	// Device identifies the device the message is about, if any, as
+	// "b8:0" or "c1:3" for block or character devices, "n2" for
+	// network interface 2, or "+subsystem:name" for others.
+	Device string
+	// Lost is the number of messages immediately before this one
+	// which Stream couldn't send, as they were overwritten in the ring
+	// buffer first.
+	Lost uint64
 }
 
 // Messages retrieves all kernel ring buffer messages and returns
@@ -0,0 +1,168 @@
+package dmesg
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EventKind says what sort of trouble a kernel Event reports.
+type EventKind int
+
+const (
+	// OOMKill is a process killed by the OOM killer, for the whole
+	// system or a memory cgroup.
+	OOMKill EventKind = iota
+	// Segfault is a process killed by SIGSEGV for touching memory it
+	// had no access to.
+	Segfault
+	// HungTask is a process which spent too long in uninterruptible
+	// sleep, usually waiting on storage.
+	HungTask
+	// SoftLockup is a CPU which ran kernel code for too long without
+	// scheduling.
+	SoftLockup
+	// MachineCheck is a hardware error reported by the processor.
+	MachineCheck
+	// IOError is a failed read or write on a block device.
+	IOError
+)
+
+var eventKinds = []string{"oom-kill", "segfault", "hung-task", "soft-lockup", "machine-check", "io-error"}
+
+func (k EventKind) String() string {
+	if k < 0 || int(k) >= len(eventKinds) {
+		return "unknown"
+	}
+	return eventKinds[k]
+}
+
+// Event is a kernel message reporting trouble, with the details the
+// kernel gave.  Fields which don't apply to its Kind are zero.
+type Event struct {
+	Kind EventKind
+	// Message is the message the event was read from.
+	Message *Message
+	// PID and Comm identify the process involved, for OOMKill,
+	// Segfault, HungTask and SoftLockup events.
+	PID  int
+	Comm string
+	// TotalVM, AnonRSS, FileRSS and ShmemRSS are the memory of an
+	// OOMKill's victim when it was killed, in bytes.  Kernels before
+	// 4.5 don't give ShmemRSS.
+	TotalVM  uint64
+	AnonRSS  uint64
+	FileRSS  uint64
+	ShmemRSS uint64
+	// Address is the memory a Segfault's process tried to access,
+	// from the instruction at IP.
+	Address uint64
+	IP      uint64
+	// Duration is how long a HungTask was blocked or a SoftLockup's
+	// CPU was stuck.
+	Duration time.Duration
+	// CPU is the processor which locked up or reported a machine
+	// check, or -1 if the kernel didn't say.
+	CPU int
+	// Disk and Sector locate an IOError.  Sector is zero for errors
+	// reported by a filesystem's buffer cache, which gives a block
+	// number instead.
+	Disk   string
+	Sector uint64
+}
+
+// RSS is the resident memory of an OOMKill's victim when it was
+// killed, in bytes.
+func (e *Event) RSS() uint64 {
+	return e.AnonRSS + e.FileRSS + e.ShmemRSS
+}
+
+var (
+	oomKillPattern     = regexp.MustCompile(`^(?:Out of memory: |Memory cgroup out of memory: )?Killed process (\d+)(?:, UID \d+,)? \((.*)\)(.*)$`)
+	oomMemoryPattern   = regexp.MustCompile(`(total-vm|anon-rss|file-rss|shmem-rss):(\d+)kB`)
+	segfaultPattern    = regexp.MustCompile(`^(.*)\[(\d+)\]: segfault at ([0-9a-f]+) ip ([0-9a-f]+) `)
+	hungTaskPattern    = regexp.MustCompile(`^INFO: task (.*):(\d+) blocked for more than (\d+) seconds\.`)
+	softLockupPattern  = regexp.MustCompile(`BUG: soft lockup - CPU#(\d+) stuck for (\d+)s! \[(.*):(\d+)\]`)
+	mceHeaderPattern   = regexp.MustCompile(`\[Hardware Error\]: CPU (\d+): Machine Check`)
+	blockErrorPattern  = regexp.MustCompile(` error, dev ([^, ]+), sector (\d+)`)
+	bufferErrorPattern = regexp.MustCompile(`^Buffer I/O error on dev(?:ice)? ([^, ]+),`)
+)
+
+// Classify returns the event message reports, or nil if it isn't
+// one.  Only the kernel's own messages count, so that a process
+// writing to /dev/kmsg can't forge one.
+func Classify(message *Message) *Event {
+	if message.Facility != 0 {
+		return nil
+	}
+	text := message.Message
+	event := &Event{Message: message, CPU: -1}
+	if match := oomKillPattern.FindStringSubmatch(text); match != nil {
+		event.Kind = OOMKill
+		event.PID, _ = strconv.Atoi(match[1])
+		event.Comm = match[2]
+		for _, field := range oomMemoryPattern.FindAllStringSubmatch(match[3], -1) {
+			kb, _ := strconv.ParseUint(field[2], 10, 64)
+			switch field[1] {
+			case "total-vm":
+				event.TotalVM = kb * 1024
+			case "anon-rss":
+				event.AnonRSS = kb * 1024
+			case "file-rss":
+				event.FileRSS = kb * 1024
+			case "shmem-rss":
+				event.ShmemRSS = kb * 1024
+			}
+		}
+		return event
+	}
+	if match := segfaultPattern.FindStringSubmatch(text); match != nil {
+		event.Kind = Segfault
+		event.Comm = match[1]
+		event.PID, _ = strconv.Atoi(match[2])
+		event.Address, _ = strconv.ParseUint(match[3], 16, 64)
+		event.IP, _ = strconv.ParseUint(match[4], 16, 64)
+		return event
+	}
+	if match := hungTaskPattern.FindStringSubmatch(text); match != nil {
+		event.Kind = HungTask
+		event.Comm = match[1]
+		event.PID, _ = strconv.Atoi(match[2])
+		seconds, _ := strconv.Atoi(match[3])
+		event.Duration = time.Duration(seconds) * time.Second
+		return event
+	}
+	if match := softLockupPattern.FindStringSubmatch(text); match != nil {
+		event.Kind = SoftLockup
+		event.CPU, _ = strconv.Atoi(match[1])
+		seconds, _ := strconv.Atoi(match[2])
+		event.Duration = time.Duration(seconds) * time.Second
+		event.Comm = match[3]
+		event.PID, _ = strconv.Atoi(match[4])
+		return event
+	}
+	// a machine check is reported over several "[Hardware Error]"
+	// lines, of which only the first says which CPU it was on
+	if match := mceHeaderPattern.FindStringSubmatch(text); match != nil {
+		event.Kind = MachineCheck
+		event.CPU, _ = strconv.Atoi(match[1])
+		return event
+	}
+	if strings.Contains(text, "Machine check events logged") {
+		event.Kind = MachineCheck
+		return event
+	}
+	if match := blockErrorPattern.FindStringSubmatch(text); match != nil {
+		event.Kind = IOError
+		event.Disk = match[1]
+		event.Sector, _ = strconv.ParseUint(match[2], 10, 64)
+		return event
+	}
+	if match := bufferErrorPattern.FindStringSubmatch(text); match != nil {
+		event.Kind = IOError
+		event.Disk = match[1]
+		return event
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package dmesg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	// real lines from a range of kernels
+	for _, test := range []struct {
+		line  string
+		event Event
+	}{
+		// 5.x and later
+		{
+			"Out of memory: Killed process 2453 (stress) total-vm:1058716kB, anon-rss:1048692kB, file-rss:4kB, shmem-rss:0kB, UID:0 pgtables:2104kB oom_score_adj:0",
+			Event{Kind: OOMKill, PID: 2453, Comm: "stress", TotalVM: 1058716 * 1024, AnonRSS: 1048692 * 1024, FileRSS: 4 * 1024, CPU: -1},
+		},
+		{
+			"Memory cgroup out of memory: Killed process 31457 (python3) total-vm:2151632kB, anon-rss:1043340kB, file-rss:30940kB, shmem-rss:12kB, UID:1000 pgtables:2412kB oom_score_adj:985",
+			Event{Kind: OOMKill, PID: 31457, Comm: "python3", TotalVM: 2151632 * 1024, AnonRSS: 1043340 * 1024, FileRSS: 30940 * 1024, ShmemRSS: 12 * 1024, CPU: -1},
+		},
+		// 4.x, after "Out of memory: Kill process 1917 (java) score
+		// 898 or sacrifice child"
+		{
+			"Killed process 1917 (java) total-vm:5238844kB, anon-rss:3719288kB, file-rss:0kB",
+			Event{Kind: OOMKill, PID: 1917, Comm: "java", TotalVM: 5238844 * 1024, AnonRSS: 3719288 * 1024, CPU: -1},
+		},
+		// 2.6.32 on RHEL 6
+		{
+			"Killed process 4150, UID 498, (mysqld) total-vm:3067900kB, anon-rss:1813428kB, file-rss:32kB",
+			Event{Kind: OOMKill, PID: 4150, Comm: "mysqld", TotalVM: 3067900 * 1024, AnonRSS: 1813428 * 1024, FileRSS: 32 * 1024, CPU: -1},
+		},
+		{
+			"Out of memory: Killed process 880 (Web Content) total-vm:2890116kB, anon-rss:301604kB, file-rss:0kB, shmem-rss:6872kB, UID:1000 pgtables:2640kB oom_score_adj:100",
+			Event{Kind: OOMKill, PID: 880, Comm: "Web Content", TotalVM: 2890116 * 1024, AnonRSS: 301604 * 1024, ShmemRSS: 6872 * 1024, CPU: -1},
+		},
+		{
+			"a.out[27151]: segfault at 0 ip 000055f1e3a0613d sp 00007ffc5a51a410 error 6 in a.out[55f1e3a06000+1000] likely on CPU 2 (core 2, socket 0)",
+			Event{Kind: Segfault, PID: 27151, Comm: "a.out", Address: 0, IP: 0x55f1e3a0613d, CPU: -1},
+		},
+		{
+			"node[4093]: segfault at 7ffd3a9e8ff8 ip 0000000000f2ba4c sp 00007ffd3a9e9000 error 6 in node[400000+1e7d000]",
+			Event{Kind: Segfault, PID: 4093, Comm: "node", Address: 0x7ffd3a9e8ff8, IP: 0xf2ba4c, CPU: -1},
+		},
+		{
+			"Chrome_ChildIOT[3121]: segfault at 10 ip 00007f1c4ae6e3d6 sp 00007f1c3dffe8d0 error 4 in libxul.so[7f1c48a00000+5c5d000]",
+			Event{Kind: Segfault, PID: 3121, Comm: "Chrome_ChildIOT", Address: 0x10, IP: 0x7f1c4ae6e3d6, CPU: -1},
+		},
+		{
+			"INFO: task jbd2/sda1-8:312 blocked for more than 120 seconds.",
+			Event{Kind: HungTask, PID: 312, Comm: "jbd2/sda1-8", Duration: 120 * time.Second, CPU: -1},
+		},
+		{
+			"INFO: task kworker/u16:3:2894 blocked for more than 245 seconds.",
+			Event{Kind: HungTask, PID: 2894, Comm: "kworker/u16:3", Duration: 245 * time.Second, CPU: -1},
+		},
+		{
+			"watchdog: BUG: soft lockup - CPU#2 stuck for 22s! [kworker/2:1:12345]",
+			Event{Kind: SoftLockup, PID: 12345, Comm: "kworker/2:1", Duration: 22 * time.Second, CPU: 2},
+		},
+		{
+			"BUG: soft lockup - CPU#0 stuck for 67s! [java:8176]",
+			Event{Kind: SoftLockup, PID: 8176, Comm: "java", Duration: 67 * time.Second, CPU: 0},
+		},
+		{
+			"mce: [Hardware Error]: CPU 1: Machine Check: 0 Bank 8: ee0000000040110a",
+			Event{Kind: MachineCheck, CPU: 1},
+		},
+		{
+			"mce: [Hardware Error]: Machine check events logged",
+			Event{Kind: MachineCheck, CPU: -1},
+		},
+		{
+			"blk_update_request: I/O error, dev sda, sector 1953525000 op 0x0:(READ) flags 0x0 phys_seg 1 prio class 0",
+			Event{Kind: IOError, Disk: "sda", Sector: 1953525000, CPU: -1},
+		},
+		{
+			"I/O error, dev nvme0n1, sector 4096 op 0x1:(WRITE) flags 0x800 phys_seg 1 prio class 2",
+			Event{Kind: IOError, Disk: "nvme0n1", Sector: 4096, CPU: -1},
+		},
+		{
+			"print_req_error: critical medium error, dev sdb, sector 37520",
+			Event{Kind: IOError, Disk: "sdb", Sector: 37520, CPU: -1},
+		},
+		{
+			"Buffer I/O error on dev sdb1, logical block 0, async page read",
+			Event{Kind: IOError, Disk: "sdb1", CPU: -1},
+		},
+	} {
+		message := &Message{Level: 3, Message: test.line}
+		event := Classify(message)
+		if !assert.NotNil(t, event, test.line) {
+			continue
+		}
+		assert.Equal(t, message, event.Message)
+		event.Message = nil
+		assert.Equal(t, test.event, *event, test.line)
+	}
+}
+
+func TestClassifyIgnored(t *testing.T) {
+	for _, line := range []string{
+		// the same kill as the "Killed process" line after it
+		"Out of memory: Kill process 1917 (java) score 898 or sacrifice child",
+		"oom-kill:constraint=CONSTRAINT_NONE,nodemask=(null),cpuset=/,mems_allowed=0,global_oom,task_memcg=/user.slice,task=stress,pid=2453,uid=0",
+		"oom_reaper: reaped process 2453 (stress), now anon-rss:0kB, file-rss:0kB, shmem-rss:0kB",
+		"mce: CPU0: Core temperature above threshold, cpu clock throttled (total events = 1)",
+		"NET: Registered protocol family 10",
+	} {
+		assert.Nil(t, Classify(&Message{Level: 4, Message: line}), line)
+	}
+
+	// userspace can write to /dev/kmsg, but not as the kernel
+	forged := &Message{Level: 3, Facility: 1, Message: "Out of memory: Killed process 1 (init) total-vm:1kB, anon-rss:1kB, file-rss:0kB"}
+	assert.Nil(t, Classify(forged))
+}
+
+func TestEventRSS(t *testing.T) {
+	event := Classify(&Message{Message: "Out of memory: Killed process 2453 (stress) total-vm:1058716kB, anon-rss:1048692kB, file-rss:4kB, shmem-rss:8kB"})
+	if assert.NotNil(t, event) {
+		assert.Equal(t, uint64(1048704*1024), event.RSS())
+		assert.Equal(t, "oom-kill", event.Kind.String())
+	}
+}
+
+func TestClassifyMachineCheck(t *testing.T) {
+	// one machine check, as a 4.x kernel logs it
+	var events []*Event
+	for _, line := range []string{
+		"mce: [Hardware Error]: CPU 2: Machine Check Exception: 5 Bank 4: b200000000070005",
+		"mce: [Hardware Error]: RIP !INEXACT! 10:<ffffffff8103e4a5> {intel_idle+0xc5/0x150}",
+		"mce: [Hardware Error]: TSC 2e9bc1a29e3 ADDR fef1c140 MISC 38a0000086",
+		"mce: [Hardware Error]: PROCESSOR 0:306c3 TIME 1481637410 SOCKET 0 APIC 4 microcode 1c",
+		"mce: [Hardware Error]: Run the above through 'mcelog --ascii'",
+		"mce: [Hardware Error]: Machine check: Processor context corrupt",
+		"Kernel panic - not syncing: Fatal machine check",
+	} {
+		if event := Classify(&Message{Level: 0, Message: line}); event != nil {
+			events = append(events, event)
+		}
+	}
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, MachineCheck, events[0].Kind)
+		assert.Equal(t, 2, events[0].CPU)
+	}
+}
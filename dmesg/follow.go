@@ -0,0 +1,85 @@
+package dmesg
+
+import (
+	"context"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+)
+
+// Reader is anything kernel messages are read from one at a time, as
+// they are from a Kmsg.
+type Reader interface {
+	// Next returns the next message, or io.EOF if there are no more
+	// for now.
+	Next() (*Message, error)
+}
+
+// NextParsed returns the next message r has which could be parsed,
+// logging and skipping any *RecordError on the way.  Any other error,
+// including io.EOF, is returned as it is.
+func NextParsed(r Reader) (*Message, error) {
+	for {
+		message, err := r.Next()
+		var recordErr *RecordError
+		if errors.As(err, &recordErr) {
+			log.WithError(err).Warning("Skipping kernel message")
+			continue
+		}
+		return message, err
+	}
+}
+
+// waitingSource is a source which can block until it has more
+// messages, as a Kmsg can.
+type waitingSource interface {
+	source
+	// Wait blocks until Next has a message, or ctx is done.
+	Wait(ctx context.Context) error
+}
+
+// Follow sends kernel messages to out as they are logged, sleeping
+// in between rather than polling, until ctx is done.  It starts from
+// the message with sequence number cursor, saved during the boot with
+// id boot, which work as Cursor and BootID in StreamOptions, and
+// counts any that are overwritten before they can be read in the Lost
+// field of the next message sent.  out is closed when Follow returns,
+// with ctx.Err() or whatever stopped it reading.
+func Follow(ctx context.Context, out chan<- *Message, cursor uint64, boot string) error {
+	defer close(out)
+	state, err := New()
+	if err != nil {
+		return err
+	}
+	kmsg, err := state.OpenKmsg()
+	if err != nil {
+		return err
+	}
+	defer kmsg.Close()
+	return follow(ctx, kmsg, resume(state, cursor, boot), out)
+}
+
+func follow(ctx context.Context, src waitingSource, c *cursor, out chan<- *Message) error {
+	for {
+		message, err := NextParsed(src)
+		if err == io.EOF {
+			if err := src.Wait(ctx); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !c.advance(message) {
+			continue
+		}
+		if message.Lost > 0 {
+			log.WithField("lost", message.Lost).Warning("Ring buffer overwritten before messages could be read")
+		}
+		select {
+		case out <- message:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -67,7 +67,7 @@ func parseHMS(parts []string) (*Message, error) {
 	// prensecs is of course a decimal.  True value is prensecs /
 	// 10^(len((parts[2])); conversion to nanoseconds is that times 1e9.
 
-	return &Message{level, time.Unix(hours*60*60+minutes*60+secs, nsecs), parts[6]}, nil
+	return &Message{Level: level, Timestamp: time.Unix(hours*60*60+minutes*60+secs, nsecs), Message: parts[6]}, nil
 }
 
 func parseBracketed(parts []string) (*Message, error) {
@@ -102,5 +102,5 @@ func parseBracketed(parts []string) (*Message, error) {
 		}
 	}
 
-	return &Message{level, time.Unix(secs, nsecs), parts[4]}, nil
+	return &Message{Level: level, Timestamp: time.Unix(secs, nsecs), Message: parts[4]}, nil
 }
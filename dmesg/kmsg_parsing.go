@@ -0,0 +1,102 @@
+package dmesg
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RecordError is returned by Kmsg.Next for a record it couldn't
+// parse.  The record has still been read, so the next call carries on
+// after it.
+type RecordError struct {
+	Err error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("Couldn't parse kernel record: %v", e.Err)
+}
+
+// Unwrap returns the underlying parser error.
+func (e *RecordError) Unwrap() error {
+	return e.Err
+}
+
+// parseKmsgRecord reads a single record from /dev/kmsg, which is laid
+// out as described in the kernel's
+// Documentation/ABI/testing/dev-kmsg:
+//
+//	priority,sequence,timestamp_us,flags[,...];message
+//	 KEY=value
+//
+// The timestamp is relative to bootTime.
+func parseKmsgRecord(record []byte, bootTime time.Time) (*Message, error) {
+	lines := strings.Split(strings.TrimSuffix(string(record), "\n"), "\n")
+	semicolon := strings.IndexByte(lines[0], ';')
+	if semicolon < 0 {
+		return nil, fmt.Errorf("No message in record %q", lines[0])
+	}
+	prefix := strings.Split(lines[0][:semicolon], ",")
+	if len(prefix) < 4 {
+		return nil, fmt.Errorf("Short record prefix %q", lines[0][:semicolon])
+	}
+	priority, err := strconv.ParseInt(prefix[0], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	sequence, err := strconv.ParseUint(prefix[1], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	usecs, err := strconv.ParseInt(prefix[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	message := &Message{
+		Level:     priority & 7,
+		Facility:  priority >> 3,
+		Sequence:  sequence,
+		Timestamp: bootTime.Add(time.Duration(usecs) * time.Microsecond),
+		Message:   unescapeKmsg(lines[0][semicolon+1:]),
+		// older kernels flag fragments with 'c', newer ones with '+'
+		Fragment: prefix[3] == "c" || prefix[3] == "+",
+	}
+	for _, line := range lines[1:] {
+		if !strings.HasPrefix(line, " ") {
+			return nil, fmt.Errorf("Malformed dictionary line %q", line)
+		}
+		parts := strings.SplitN(line[1:], "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Malformed dictionary line %q", line)
+		}
+		switch parts[0] {
+		case "SUBSYSTEM":
+			message.Subsystem = unescapeKmsg(parts[1])
+		case "DEVICE":
+			message.Device = unescapeKmsg(parts[1])
+		}
+	}
+	return message, nil
+}
+
+// unescapeKmsg undoes the kernel's escaping of unprintable bytes,
+// including newlines and backslashes, as \xNN.
+func unescapeKmsg(text string) string {
+	if !strings.Contains(text, `\x`) {
+		return text
+	}
+	var buf bytes.Buffer
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\\' && i+3 < len(text) && text[i+1] == 'x' {
+			if b, err := strconv.ParseUint(text[i+2:i+4], 16, 8); err == nil {
+				buf.WriteByte(byte(b))
+				i += 3
+				continue
+			}
+		}
+		buf.WriteByte(text[i])
+	}
+	return buf.String()
+}
@@ -0,0 +1,85 @@
+package dmesg
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var boot = time.Unix(1062191376, 0)
+
+func TestKmsgRecord(t *testing.T) {
+	message, err := parseKmsgRecord([]byte("6,339,5140900,-;NET: Registered protocol family 10\n"), boot)
+	if assert.NoError(t, err) {
+		assert.Equal(t, &Message{
+			Level:     6,
+			Timestamp: boot.Add(5140900 * time.Microsecond),
+			Message:   "NET: Registered protocol family 10",
+			Sequence:  339,
+		}, message)
+	}
+}
+
+func TestKmsgFacility(t *testing.T) {
+	message, err := parseKmsgRecord([]byte("30,340,5690716,-;udevd[80]: starting version 181\n"), boot)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(6), message.Level)
+		assert.Equal(t, int64(3), message.Facility)
+	}
+}
+
+func TestKmsgDictionary(t *testing.T) {
+	message, err := parseKmsgRecord([]byte(`7,160,424069,-;pci_root PNP0A03:00: host bridge window [io  0x0000-0x0cf7] (ignored)
+ SUBSYSTEM=acpi
+ DEVICE=+acpi:PNP0A03:00
+`), boot)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "pci_root PNP0A03:00: host bridge window [io  0x0000-0x0cf7] (ignored)", message.Message)
+		assert.Equal(t, "acpi", message.Subsystem)
+		assert.Equal(t, "+acpi:PNP0A03:00", message.Device)
+	}
+	message, err = parseKmsgRecord([]byte(`3,1045,77434219,-;sd 0:0:0:0: [sda] tag#0 FAILED Result: hostbyte=DID_OK driverbyte=DRIVER_SENSE
+ SUBSYSTEM=scsi
+ DEVICE=+scsi:0:0:0:0
+ UNKNOWN=ignored
+`), boot)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "scsi", message.Subsystem)
+		assert.Equal(t, "+scsi:0:0:0:0", message.Device)
+	}
+}
+
+func TestKmsgFlags(t *testing.T) {
+	// extra prefix fields are reserved for future use
+	message, err := parseKmsgRecord([]byte("4,1,0,c,caller=T1;first half of a line\n"), boot)
+	if assert.NoError(t, err) {
+		assert.True(t, message.Fragment)
+		assert.Equal(t, boot, message.Timestamp)
+	}
+	message, err = parseKmsgRecord([]byte("4,2,0,+;second half\n"), boot)
+	if assert.NoError(t, err) {
+		assert.True(t, message.Fragment)
+	}
+}
+
+func TestKmsgEscapes(t *testing.T) {
+	message, err := parseKmsgRecord([]byte(`6,3,0,-;tab\x09newline\x0abackslash\x5c bad\xzz trailing\x`), boot)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "tab\tnewline\nbackslash\\ bad\\xzz trailing\\x", message.Message)
+	}
+}
+
+func TestBrokenKmsgRecord(t *testing.T) {
+	for _, record := range []string{
+		"6,339,5140900,- NET: Registered protocol family 10\n",
+		"6,339,5140900;NET: Registered protocol family 10\n",
+		"x,339,5140900,-;NET: Registered protocol family 10\n",
+		"6,-1,5140900,-;NET: Registered protocol family 10\n",
+		"6,339,soon,-;NET: Registered protocol family 10\n",
+		"6,339,5140900,-;NET: Registered protocol family 10\nSUBSYSTEM=net\n",
+		"6,339,5140900,-;NET: Registered protocol family 10\n SUBSYSTEM\n",
+	} {
+		_, err := parseKmsgRecord([]byte(record), boot)
+		assert.Error(t, err, record)
+	}
+}
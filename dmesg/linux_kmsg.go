@@ -0,0 +1,129 @@
+// +build linux
+
+package dmesg
+
+import (
+	"context"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"time"
+)
+
+// the kernel's CONSOLE_EXT_LOG_MAX, the longest record it will return
+const kmsgRecordMax = 8192
+
+// Kmsg reads structured records from /dev/kmsg, which unlike klogctl
+// keeps their sequence numbers and device information, and is allowed
+// whenever the device is readable rather than needing CAP_SYSLOG.
+type Kmsg struct {
+	fd       int
+	bootTime time.Time
+	buffer   []byte
+	// wake is a pipe which interrupts Wait when its context is done
+	wake [2]int
+}
+
+// OpenKmsg starts reading /dev/kmsg from the oldest message in the
+// ring buffer.
+func (s *State) OpenKmsg() (*Kmsg, error) {
+	fd, err := unix.Open("/dev/kmsg", unix.O_RDONLY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: "/dev/kmsg", Err: err}
+	}
+	k := &Kmsg{fd: fd, bootTime: s.bootTime, buffer: make([]byte, kmsgRecordMax)}
+	if err := unix.Pipe2(k.wake[:], unix.O_NONBLOCK|unix.O_CLOEXEC); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return k, nil
+}
+
+// Next returns the next message, or io.EOF if there are no more for
+// now, or a *RecordError if it couldn't be parsed.  Messages
+// overwritten in the ring buffer before they could be read are
+// skipped, leaving a gap in the sequence numbers.
+func (k *Kmsg) Next() (*Message, error) {
+	for {
+		n, err := unix.Read(k.fd, k.buffer)
+		switch err {
+		case nil:
+			message, err := parseKmsgRecord(k.buffer[:n], k.bootTime)
+			if err != nil {
+				return nil, &RecordError{err}
+			}
+			return message, nil
+		case unix.EPIPE, unix.EINTR:
+			// EPIPE says we were overtaken; the next read
+			// resumes from the oldest message remaining.
+			continue
+		case unix.EAGAIN:
+			return nil, io.EOF
+		default:
+			return nil, &os.PathError{Op: "read", Path: "/dev/kmsg", Err: err}
+		}
+	}
+}
+
+// SeekEnd skips the messages already logged, so that Next only
+// returns those logged from now on.
+func (k *Kmsg) SeekEnd() error {
+	if _, err := unix.Seek(k.fd, 0, unix.SEEK_END); err != nil {
+		return &os.PathError{Op: "seek", Path: "/dev/kmsg", Err: err}
+	}
+	return nil
+}
+
+// Wait blocks until there is a message to read, or ctx is done.
+func (k *Kmsg) Wait(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		unix.Write(k.wake[1], []byte{0})
+	})
+	defer stop()
+	for {
+		fds := []unix.PollFd{
+			{Fd: int32(k.fd), Events: unix.POLLIN},
+			{Fd: int32(k.wake[0]), Events: unix.POLLIN},
+		}
+		_, err := unix.Poll(fds, -1)
+		if err == unix.EINTR {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if fds[1].Revents != 0 {
+			unix.Read(k.wake[0], make([]byte, 1))
+			return ctx.Err()
+		}
+		if fds[0].Revents != 0 {
+			return nil
+		}
+	}
+}
+
+// Close stops reading.
+func (k *Kmsg) Close() error {
+	unix.Close(k.wake[0])
+	unix.Close(k.wake[1])
+	return unix.Close(k.fd)
+}
+
+// KmsgMessages retrieves all kernel ring buffer messages through
+// /dev/kmsg and returns them, or a reason why it could not.
+func (s *State) KmsgMessages() ([]*Message, error) {
+	kmsg, err := s.OpenKmsg()
+	if err != nil {
+		return nil, err
+	}
+	defer kmsg.Close()
+	var result []*Message
+	for {
+		message, err := kmsg.Next()
+		if err == io.EOF {
+			return result, nil
+		} else if err != nil {
+			return result, err
+		}
+		result = append(result, message)
+	}
+}
@@ -5,7 +5,9 @@ package dmesg
 import "bufio"
 import "os"
 import "bytes"
+import "strings"
 import "io"
+import "io/fs"
 import "golang.org/x/sys/unix"
 import "time"
 import "fmt"
@@ -18,6 +20,7 @@ const syslogActionReadAll = 3
 // for the dmesg parser.
 type State struct {
 	bootTime time.Time
+	bootID   string
 }
 
 // Current retrieves the current contents of the kernel message ring buffer.
@@ -34,8 +37,8 @@ func (s *State) Current() ([]byte, error) {
 	return buffer[:bytesread], nil
 }
 
-func uptime() (time.Time, error) {
-	file, err := os.Open("/proc/stat")
+func uptime(proc fs.FS) (time.Time, error) {
+	file, err := proc.Open("stat")
 	if err != nil {
 		return time.Unix(0, 0), err
 	}
@@ -54,15 +57,33 @@ func uptime() (time.Time, error) {
 
 // New creates a new State.
 func New() (*State, error) {
+	return NewFromProc(os.DirFS("/proc"))
+}
+
+// NewFromProc creates a new State, reading the boot time from the
+// procfs proc rather than /proc.
+func NewFromProc(proc fs.FS) (*State, error) {
 	var err error
 	s := State{}
-	s.bootTime, err = uptime()
+	s.bootTime, err = uptime(proc)
 	if err != nil {
 		return nil, err
 	}
+	// only needed to resume a stream, and missing before 2.6.27
+	if id, err := fs.ReadFile(proc, "sys/kernel/random/boot_id"); err == nil {
+		s.bootID = strings.TrimSpace(string(id))
+	}
 	return &s, nil
 }
 
+// BootID identifies the boot the system is running, or is empty if
+// the kernel doesn't say.  Save it alongside a cursor, so that a
+// stream resumed from the cursor can tell whether the system rebooted
+// in between.
+func (s *State) BootID() string {
+	return s.bootID
+}
+
 // ParseMessages reads dmesg type messages out of buffer.  To do so it
 // must read the system boot time out of /proc/stat, because dmesg
 // timestamps are relative to when the system booted.
@@ -0,0 +1,113 @@
+// +build linux
+
+package dmesg
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestNewFromProc(t *testing.T) {
+	proc := fstest.MapFS{"stat": {Data: []byte(`cpu  2255 34 2290 22625563 6290 127 456 0 0 0
+cpu0 1132 34 1441 11311718 3675 127 438 0 0 0
+intr 114930548 113199788 3 0 5 263 0 4 [... lots more numbers ...]
+ctxt 1990473
+btime 1062191376
+processes 2915
+procs_running 1
+procs_blocked 0
+`)}}
+	state, err := NewFromProc(proc)
+	if !assert.NoError(t, err) {
+		return
+	}
+	messages, err := state.ParseMessages([]byte("<6>[5.000000]booted\n"))
+	if assert.NoError(t, err) && assert.Len(t, messages, 1) {
+		assert.Equal(t, time.Unix(1062191381, 0), messages[0].Timestamp)
+	}
+
+	_, err = NewFromProc(fstest.MapFS{"stat": {Data: []byte("cpu  2255 34 2290 22625563 6290 127 456 0 0 0\n")}})
+	assert.Error(t, err)
+	_, err = NewFromProc(fstest.MapFS{})
+	assert.Error(t, err)
+}
+
+func TestResume(t *testing.T) {
+	proc := fstest.MapFS{
+		"stat":                      {Data: []byte("btime 1062191376\n")},
+		"sys/kernel/random/boot_id": {Data: []byte("7b5d6a4e-54a1-4f3a-9d3c-2f0e6b1c8a90\n")},
+	}
+	state, err := NewFromProc(proc)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "7b5d6a4e-54a1-4f3a-9d3c-2f0e6b1c8a90", state.BootID())
+	assert.Equal(t, newCursor(42), resume(state, 42, state.BootID()))
+	assert.Equal(t, newCursor(42), resume(state, 42, ""))
+	// sequence numbers started again when the system rebooted
+	assert.Equal(t, newCursor(0), resume(state, 42, "0f6c1d2e-8a3b-4c5d-9e7f-1a2b3c4d5e6f"))
+
+	// a kernel which doesn't say can't be checked
+	delete(proc, "sys/kernel/random/boot_id")
+	state, err = NewFromProc(proc)
+	if assert.NoError(t, err) {
+		assert.Empty(t, state.BootID())
+		assert.Equal(t, newCursor(42), resume(state, 42, "0f6c1d2e-8a3b-4c5d-9e7f-1a2b3c4d5e6f"))
+	}
+}
+
+func TestKmsgMessages(t *testing.T) {
+	state, err := New()
+	if !assert.NoError(t, err) {
+		return
+	}
+	messages, err := state.KmsgMessages()
+	if err != nil {
+		t.Skip("can't read /dev/kmsg:", err)
+	}
+	for i := 1; i < len(messages); i++ {
+		assert.True(t, messages[i].Sequence > messages[i-1].Sequence)
+	}
+}
+
+func TestKmsgSeekEnd(t *testing.T) {
+	state, err := New()
+	if !assert.NoError(t, err) {
+		return
+	}
+	kmsg, err := state.OpenKmsg()
+	if err != nil {
+		t.Skip("can't read /dev/kmsg:", err)
+	}
+	defer kmsg.Close()
+	if assert.NoError(t, kmsg.SeekEnd()) {
+		_, err = kmsg.Next()
+		assert.Equal(t, io.EOF, err)
+	}
+}
+
+func TestFollowKmsg(t *testing.T) {
+	state, err := New()
+	if !assert.NoError(t, err) {
+		return
+	}
+	kmsg, err := state.OpenKmsg()
+	if err != nil {
+		t.Skip("can't read /dev/kmsg:", err)
+	}
+	kmsg.Close()
+
+	// the deadline interrupts waiting once the ring buffer is read
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	out := make(chan *Message, 10)
+	result := make(chan error)
+	go func() { result <- Follow(ctx, out, 0, "") }()
+	for range out {
+	}
+	assert.Equal(t, context.DeadlineExceeded, <-result)
+}
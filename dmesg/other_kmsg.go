@@ -0,0 +1,42 @@
+// +build !linux
+
+package dmesg
+
+import "context"
+
+// Kmsg reads structured records from /dev/kmsg.  On this operating
+// system it is not supported.
+type Kmsg struct {
+}
+
+// OpenKmsg starts reading /dev/kmsg.  On this operating system it is
+// not supported.
+func (s *State) OpenKmsg() (*Kmsg, error) {
+	return nil, errNotSupported
+}
+
+// Next returns the next message.
+func (k *Kmsg) Next() (*Message, error) {
+	return nil, errNotSupported
+}
+
+// SeekEnd skips the messages already logged.
+func (k *Kmsg) SeekEnd() error {
+	return errNotSupported
+}
+
+// Wait blocks until there is a message to read.
+func (k *Kmsg) Wait(ctx context.Context) error {
+	return errNotSupported
+}
+
+// Close stops reading.
+func (k *Kmsg) Close() error {
+	return errNotSupported
+}
+
+// KmsgMessages retrieves all kernel ring buffer messages through
+// /dev/kmsg.  On this operating system it is not supported.
+func (s *State) KmsgMessages() ([]*Message, error) {
+	return nil, errNotSupported
+}
@@ -3,6 +3,7 @@
 package dmesg
 
 import "errors"
+import "io/fs"
 
 var errNotSupported = errors.New("Not supported on this operating system")
 
@@ -22,6 +23,17 @@ func New() (*State, error) {
 	return nil, errNotSupported
 }
 
+// NewFromProc creates a new State.
+func NewFromProc(proc fs.FS) (*State, error) {
+	return nil, errNotSupported
+}
+
+// BootID identifies the boot the system is running.  On this
+// operating system it is always empty.
+func (s *State) BootID() string {
+	return ""
+}
+
 // ParseMessages reads dmesg type messages out of buffer.  On this
 // operating system, it is not supported.
 func (s *State) ParseMessages([]byte) ([]*Message, error) {
@@ -1,73 +1,147 @@
 package dmesg
 
 import (
+	"errors"
+	"github.com/meteor/procmon/clock"
 	log "github.com/sirupsen/logrus"
+	"io"
 	"time"
 )
 
+// StreamOptions configure StreamWithOptions.
+type StreamOptions struct {
+	// Interval is the time between reads of the ring buffer.
+	Interval time.Duration
+	// Cursor is the sequence number of the first message to send, so
+	// that a stream can resume where an earlier one stopped by passing
+	// one more than the last Sequence it sent.  Zero starts from the
+	// oldest message in the ring buffer.
+	Cursor uint64
+	// BootID is what State.BootID returned when Cursor was saved.
+	// Sequence numbers start again from zero when the system reboots,
+	// so if it is set and has changed since, Cursor is ignored and
+	// the stream starts from the oldest message.
+	BootID string
+	// Clock measures Interval; nil means the system clock.
+	Clock clock.Clock
+}
+
 // Stream creates a goroutine that, every sampleTime ticks, will send
 // new dmesg messages to out.  It also listens on stop, in case you
-// need to abort the goroutine.  If there is an error setting up the
-// initial state, it is returned, but otherwise errors are logged and
-// otherwise ignored.
+// need to abort the goroutine, even while it waits to send, and
+// closes out once it has finished.  If there is an error setting up
+// the initial state, it is returned, but otherwise errors are logged
+// and otherwise ignored.  Follow is usually better, as it doesn't
+// poll.
 func Stream(out chan<- *Message, stop <-chan bool, sampleTime time.Duration) error {
+	return StreamWithOptions(out, stop, StreamOptions{Interval: sampleTime})
+}
+
+// StreamWithOptions is Stream configured by opts.  Messages are read
+// from /dev/kmsg and sent exactly once, in sequence order; any that
+// were overwritten in the ring buffer before they could be read are
+// counted in the Lost field of the next message sent.
+func StreamWithOptions(out chan<- *Message, stop <-chan bool, opts StreamOptions) error {
+	if opts.Clock == nil {
+		opts.Clock = clock.Real
+	}
 	state, err := New()
 	if err != nil {
 		return err
 	}
-	go doStream(state, out, stop, sampleTime)
+	kmsg, err := state.OpenKmsg()
+	if err != nil {
+		return err
+	}
+	go doStream(kmsg, resume(state, opts.Cursor, opts.BootID), out, stop, opts.Clock.NewTicker(opts.Interval))
 	return nil
 }
 
-func doTick(state *State, out chan<- *Message, lastMessage *Message) (*Message, error) {
-	messages, err := state.Messages()
-	if err != nil {
-		return nil, err
+// source is where a stream reads messages, normally a Kmsg.
+type source interface {
+	Reader
+	Close() error
+}
+
+// cursor tracks the sequence number of the next message to send.
+type cursor struct {
+	next uint64
+	// started is set once next is known; until then no message
+	// counts as lost.
+	started bool
+}
+
+// newCursor starts at next, or at the oldest message if next is zero.
+func newCursor(next uint64) *cursor {
+	return &cursor{next, next != 0}
+}
+
+// resume starts at next if it was saved during the current boot, and
+// at the oldest message if not.
+func resume(state *State, next uint64, boot string) *cursor {
+	if next != 0 && boot != "" && state.BootID() != "" && boot != state.BootID() {
+		log.WithField("boot", boot).Info("Cursor is from an earlier boot; starting from the oldest message")
+		next = 0
+	}
+	return newCursor(next)
+}
+
+// advance moves past message, unless it has already been sent, and
+// counts the messages skipped to reach it.
+func (c *cursor) advance(message *Message) bool {
+	if c.started && message.Sequence < c.next {
+		return false
+	}
+	if c.started {
+		message.Lost = message.Sequence - c.next
 	}
+	c.next, c.started = message.Sequence+1, true
+	return true
+}
 
-	if lastMessage == nil {
-		for _, message := range messages {
-			lastMessage = message
-			out <- message
+// errStopped is returned by doTick when stop interrupts it.
+var errStopped = errors.New("Stopped while sending")
+
+// doTick sends every message which has arrived since the last tick.
+func doTick(src source, c *cursor, out chan<- *Message, stop <-chan bool) error {
+	for {
+		message, err := NextParsed(src)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !c.advance(message) {
+			continue
 		}
-	} else {
-		hasSeenLast := false
-		for _, message := range messages {
-			if message.Timestamp.After(lastMessage.Timestamp) {
-				log.Debug("missed some, resuming where available")
-				hasSeenLast = true
-			} else if message == lastMessage {
-				hasSeenLast = true
-			}
-			if hasSeenLast {
-				lastMessage = message
-				out <- message
-			}
+		if message.Lost > 0 {
+			log.WithField("lost", message.Lost).Warning("Ring buffer overwritten before messages could be read")
+		}
+		select {
+		case out <- message:
+		case <-stop:
+			return errStopped
 		}
 	}
-
-	return lastMessage, nil
 }
 
-func doStream(state *State, out chan<- *Message, stop <-chan bool, sampleTime time.Duration) {
-	var lastMessage *Message
-	var err error
-	ticker := time.NewTicker(sampleTime)
+func doStream(src source, c *cursor, out chan<- *Message, stop <-chan bool, ticker clock.Ticker) {
+	defer close(out)
+	defer src.Close()
 	defer ticker.Stop()
-	lastMessage, err = doTick(state, out, lastMessage)
-	if err != nil {
-		log.WithError(err).Warning("Messages returned error; hoping it clears up")
-	}
-	for _ = range ticker.C {
+	for {
+		err := doTick(src, c, out, stop)
+		if err == errStopped {
+			log.Debug("Terminating as requested")
+			return
+		} else if err != nil {
+			log.WithError(err).Warning("Messages returned error; hoping it clears up")
+		}
 		select {
 		case <-stop:
 			log.Debug("Terminating as requested")
 			return
-		default:
-		}
-		lastMessage, err = doTick(state, out, lastMessage)
-		if err != nil {
-			log.WithError(err).Warning("Messages returned error; hoping it clears up")
+		case <-ticker.C():
 		}
 	}
 }
@@ -0,0 +1,203 @@
+package dmesg
+
+import (
+	"context"
+	"errors"
+	"github.com/meteor/procmon/clock"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSource hands out messages as they are added, signalling eof
+// whenever it runs out, and more whenever messages are added.
+type fakeSource struct {
+	lock     sync.Mutex
+	messages []*Message
+	closed   bool
+	eof      chan struct{}
+	more     chan struct{}
+}
+
+func (s *fakeSource) add(messages ...*Message) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.messages = append(s.messages, messages...)
+	select {
+	case s.more <- struct{}{}:
+	default:
+	}
+}
+
+func (s *fakeSource) Next() (*Message, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.messages) == 0 {
+		select {
+		case s.eof <- struct{}{}:
+		default:
+		}
+		return nil, io.EOF
+	}
+	message := s.messages[0]
+	s.messages = s.messages[1:]
+	if message == nil {
+		return nil, &RecordError{errors.New("unparseable record")}
+	}
+	return message, nil
+}
+
+func (s *fakeSource) Wait(ctx context.Context) error {
+	s.lock.Lock()
+	waiting := len(s.messages) == 0
+	s.lock.Unlock()
+	if !waiting {
+		return nil
+	}
+	select {
+	case <-s.more:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *fakeSource) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	return nil
+}
+
+func numbered(sequences ...uint64) []*Message {
+	var messages []*Message
+	for _, sequence := range sequences {
+		messages = append(messages, &Message{Level: 6, Sequence: sequence})
+	}
+	return messages
+}
+
+func sequences(messages ...*Message) []uint64 {
+	var result []uint64
+	for _, message := range messages {
+		result = append(result, message.Sequence)
+	}
+	return result
+}
+
+func TestCursor(t *testing.T) {
+	var c cursor
+	messages := numbered(40, 41, 41, 39, 45, 46)
+	var sent []*Message
+	for _, message := range messages {
+		if c.advance(message) {
+			sent = append(sent, message)
+		}
+	}
+	assert.Equal(t, []uint64{40, 41, 45, 46}, sequences(sent...))
+	assert.Equal(t, []uint64{0, 0, 3, 0}, []uint64{sent[0].Lost, sent[1].Lost, sent[2].Lost, sent[3].Lost})
+	assert.Equal(t, uint64(47), c.next)
+}
+
+func TestCursorResume(t *testing.T) {
+	c := cursor{42, true}
+	messages := numbered(40, 41, 42, 43)
+	assert.False(t, c.advance(messages[0]))
+	assert.False(t, c.advance(messages[1]))
+	assert.True(t, c.advance(messages[2]))
+	assert.Zero(t, messages[2].Lost)
+
+	// the ring buffer wrapped while we weren't running
+	c = cursor{42, true}
+	message := &Message{Sequence: 50}
+	assert.True(t, c.advance(message))
+	assert.Equal(t, uint64(8), message.Lost)
+}
+
+func TestStream(t *testing.T) {
+	c := clock.NewFake(time.Unix(1000, 0))
+	src := &fakeSource{eof: make(chan struct{}, 10)}
+	out := make(chan *Message, 10)
+	stop := make(chan bool)
+	done := make(chan struct{})
+
+	src.add(numbered(1, 2)...)
+	ticker := c.NewTicker(time.Second)
+	go func() {
+		doStream(src, &cursor{}, out, stop, ticker)
+		close(done)
+	}()
+	assert.Equal(t, []uint64{1, 2}, sequences(<-out, <-out))
+	<-src.eof
+
+	// an unreadable record is logged and skipped, and the rest are
+	// read in the same tick
+	src.add(numbered(3)...)
+	src.add(nil)
+	src.add(numbered(6)...)
+	c.Advance(time.Second)
+	third, sixth := <-out, <-out
+	<-src.eof
+	assert.Equal(t, []uint64{3, 6}, sequences(third, sixth))
+	assert.Equal(t, uint64(2), sixth.Lost)
+
+	// stopping doesn't wait for the next tick
+	close(stop)
+	<-done
+	_, ok := <-out
+	assert.False(t, ok)
+	assert.True(t, src.closed)
+	assert.Equal(t, 0, c.Waiters())
+}
+
+func TestStreamStopSending(t *testing.T) {
+	c := clock.NewFake(time.Unix(1000, 0))
+	src := &fakeSource{}
+	out := make(chan *Message)
+	stop := make(chan bool)
+	done := make(chan struct{})
+
+	// nobody receives the messages
+	src.add(numbered(1, 2)...)
+	go func() {
+		doStream(src, &cursor{}, out, stop, c.NewTicker(time.Second))
+		close(done)
+	}()
+	close(stop)
+	<-done
+	_, ok := <-out
+	assert.False(t, ok)
+	assert.True(t, src.closed)
+}
+
+func TestFollow(t *testing.T) {
+	src := &fakeSource{more: make(chan struct{}, 1)}
+	out := make(chan *Message)
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+
+	src.add(numbered(1, 2)...)
+	src.add(nil)
+	src.add(numbered(4)...)
+	go func() { result <- follow(ctx, src, newCursor(0), out) }()
+	messages := []*Message{<-out, <-out, <-out}
+	assert.Equal(t, []uint64{1, 2, 4}, sequences(messages...))
+	assert.Equal(t, uint64(1), messages[2].Lost)
+
+	// messages are sent as soon as they arrive
+	src.add(numbered(5)...)
+	assert.Equal(t, uint64(5), (<-out).Sequence)
+
+	// cancelling interrupts waiting for a message
+	cancel()
+	assert.Equal(t, context.Canceled, <-result)
+
+	// and sending one nobody receives
+	src.add(numbered(6)...)
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() { result <- follow(ctx, src, newCursor(6), out) }()
+	cancel()
+	assert.Equal(t, context.Canceled, <-result)
+}
@@ -20,7 +20,7 @@ func Mine() (*Instance, error) {
 	}
 	instance, ok := LookupName(string(body))
 	if !ok {
-		return nil, fmt.Errorf("Couldn't find instance type %q %q", body, instance)
+		return nil, fmt.Errorf("Couldn't find instance type %q", body)
 	}
 	return instance, nil
 }
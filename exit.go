@@ -0,0 +1,116 @@
+package procmon
+
+import (
+	"fmt"
+	"github.com/meteor/procmon/dmesg"
+)
+
+// ExitCause says what ended a monitored process.
+type ExitCause int
+
+const (
+	// Exited means the kernel didn't report killing the process, so
+	// it exited by itself or was sent a signal by another process.
+	Exited ExitCause = iota
+	// OOMKilled means the OOM killer chose the process to free
+	// memory.
+	OOMKilled
+	// Segfaulted means the process touched memory it had no access
+	// to.
+	Segfaulted
+)
+
+func (c ExitCause) String() string {
+	switch c {
+	case OOMKilled:
+		return "killed by OOM"
+	case Segfaulted:
+		return "segfault"
+	}
+	return "exited"
+}
+
+// Exit describes how a monitored process went away.
+type Exit struct {
+	Cause ExitCause
+	// Event is the kernel's report of an OOM kill, giving the
+	// process's memory when it was killed, or of a segfault, giving
+	// the address it faulted on.  It is nil if the process Exited.
+	Event *dmesg.Event
+}
+
+func (e *Exit) String() string {
+	switch e.Cause {
+	case OOMKilled:
+		return fmt.Sprintf("killed by OOM with %d kB resident", e.Event.RSS()/1024)
+	case Segfaulted:
+		return fmt.Sprintf("segfault at %#x ip %#x", e.Event.Address, e.Event.IP)
+	}
+	return e.Cause.String()
+}
+
+// kernelLog is where a Monitor looks for the kernel's reports on the
+// process, normally /dev/kmsg read from when monitoring began.
+type kernelLog interface {
+	dmesg.Reader
+	Close() error
+}
+
+// openKernelLog starts reading the messages the kernel logs from now
+// on.
+func openKernelLog() (kernelLog, error) {
+	state, err := dmesg.New()
+	if err != nil {
+		return nil, err
+	}
+	kmsg, err := state.OpenKmsg()
+	if err != nil {
+		return nil, err
+	}
+	if err := kmsg.SeekEnd(); err != nil {
+		kmsg.Close()
+		return nil, err
+	}
+	return kmsg, nil
+}
+
+// noteTasks remembers the threads of the process, as the kernel names
+// the thread rather than the process when one segfaults.  They are
+// only needed with the kernel log, and a list which can't be read
+// leaves the last one in place.
+func (m *Monitor) noteTasks() {
+	if m.kernel == nil {
+		return
+	}
+	if tids, err := fetchTasks(m.proc, m.process); err == nil {
+		m.tids = tids
+	}
+}
+
+// exitOf reads what the kernel has logged since monitoring began for
+// a report of it killing process, or one of its threads tids.  The
+// kernel logs OOM kills and segfaults before the process finishes
+// exiting, so once it has gone the report is already there.
+func exitOf(kernel kernelLog, process int, tids map[int]bool) *Exit {
+	exit := &Exit{Cause: Exited}
+	if kernel == nil {
+		return exit
+	}
+	for {
+		// io.EOF once it's all read, or the log is unreadable
+		message, err := dmesg.NextParsed(kernel)
+		if err != nil {
+			return exit
+		}
+		event := dmesg.Classify(message)
+		if event == nil || (event.PID != process && !tids[event.PID]) {
+			continue
+		}
+		switch event.Kind {
+		case dmesg.OOMKill:
+			exit = &Exit{OOMKilled, event}
+		case dmesg.Segfault:
+			exit = &Exit{Segfaulted, event}
+		}
+	}
+}
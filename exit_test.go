@@ -0,0 +1,133 @@
+// +build linux
+
+package procmon
+
+import (
+	"errors"
+	"github.com/meteor/procmon/dmesg"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// fakeKernel is a kernel log holding messages, with nil for a record
+// that can't be parsed.
+type fakeKernel struct {
+	messages []*dmesg.Message
+}
+
+// newFakeKernel logs lines, with an empty one for a record that can't
+// be parsed.
+func newFakeKernel(lines ...string) *fakeKernel {
+	k := new(fakeKernel)
+	for _, line := range lines {
+		var message *dmesg.Message
+		if line != "" {
+			message = &dmesg.Message{Level: 3, Message: line}
+		}
+		k.messages = append(k.messages, message)
+	}
+	return k
+}
+
+func (k *fakeKernel) Next() (*dmesg.Message, error) {
+	if len(k.messages) == 0 {
+		return nil, io.EOF
+	}
+	message := k.messages[0]
+	k.messages = k.messages[1:]
+	if message == nil {
+		return nil, &dmesg.RecordError{Err: errors.New("unparseable record")}
+	}
+	return message, nil
+}
+
+func (k *fakeKernel) Close() error {
+	return nil
+}
+
+func TestExitOOMKilled(t *testing.T) {
+	f := newProcFixture()
+	m := fixtureMonitor(t, f, Options{})
+	m.kernel = newFakeKernel(
+		"fixture[101]: segfault at 0 ip 000055f1e3a0613d sp 00007ffc5a51a410 error 6 in fixture[55f1e3a06000+1000]",
+		"Out of memory: Killed process 100 (fixture) total-vm:1058716kB, anon-rss:1048692kB, file-rss:4kB, shmem-rss:0kB, UID:0 pgtables:2104kB oom_score_adj:0",
+		"oom_reaper: reaped process 100 (fixture), now anon-rss:0kB, file-rss:0kB, shmem-rss:0kB",
+	)
+	f.remove(100)
+	_, err := fixtureTick(m)
+	assert.Equal(t, ErrProcessExited, err)
+	if assert.NotNil(t, m.exit) && assert.Equal(t, OOMKilled, m.exit.Cause) {
+		assert.Equal(t, uint64(1048696*1024), m.exit.Event.RSS())
+		assert.Equal(t, "killed by OOM with 1048696 kB resident", m.exit.String())
+	}
+}
+
+func TestExitSegfaulted(t *testing.T) {
+	f := newProcFixture()
+	m := fixtureMonitor(t, f, Options{})
+	m.kernel = newFakeKernel(
+		"NET: Registered protocol family 10",
+		"",
+		"Chrome_ChildIOT[103]: segfault at 10 ip 00007f1c4ae6e3d6 sp 00007f1c3dffe8d0 error 4 in libxul.so[7f1c48a00000+5c5d000]",
+	)
+	// the kernel names the thread which faulted, one of 100's
+	f.set("100/task/103/stat", "")
+	if _, err := fixtureTick(m); !assert.NoError(t, err) {
+		return
+	}
+	f.remove(100)
+	_, err := fixtureTick(m)
+	assert.Equal(t, ErrProcessExited, err)
+	if assert.NotNil(t, m.exit) && assert.Equal(t, Segfaulted, m.exit.Cause) {
+		assert.Equal(t, uint64(0x10), m.exit.Event.Address)
+		assert.Equal(t, "segfault at 0x10 ip 0x7f1c4ae6e3d6", m.exit.String())
+	}
+}
+
+func TestExitPlain(t *testing.T) {
+	// without the kernel log
+	f := newProcFixture()
+	m := fixtureMonitor(t, f, Options{})
+	f.remove(100)
+	_, err := fixtureTick(m)
+	assert.Equal(t, ErrProcessExited, err)
+	assert.Equal(t, &Exit{Cause: Exited}, m.exit)
+
+	// with reports on other processes only
+	f = newProcFixture()
+	m = fixtureMonitor(t, f, Options{})
+	m.kernel = newFakeKernel("Out of memory: Killed process 101 (fixture) total-vm:1058716kB, anon-rss:1048692kB, file-rss:4kB, shmem-rss:0kB")
+	f.remove(100)
+	_, err = fixtureTick(m)
+	assert.Equal(t, ErrProcessExited, err)
+	assert.Equal(t, &Exit{Cause: Exited}, m.exit)
+	assert.Equal(t, "exited", m.exit.String())
+}
+
+func TestKernelExit(t *testing.T) {
+	if kernel, err := openKernelLog(); err != nil {
+		t.Skip("can't read /dev/kmsg:", err)
+	} else {
+		kernel.Close()
+	}
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skip("can't start child:", err)
+	}
+	out := make(chan Measure, 100)
+	m, err := NewWithOptions(out, cmd.Process.Pid, Options{Interval: time.Millisecond, Kernel: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Nil(t, m.Exit())
+	// killed by us, not the kernel
+	cmd.Process.Kill()
+	cmd.Wait()
+	assert.Equal(t, ErrProcessExited, m.Wait())
+	assert.Equal(t, &Exit{Cause: Exited}, m.Exit())
+	for range out {
+	}
+}
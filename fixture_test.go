@@ -0,0 +1,340 @@
+// +build linux
+
+package procmon
+
+import (
+	"context"
+	"fmt"
+	"github.com/meteor/procmon/clock"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// procFixture is a fake procfs holding a single process, 100, which
+// has a child, 101.
+type procFixture fstest.MapFS
+
+func newProcFixture() procFixture {
+	f := procFixture{}
+	f.setCPU(1000, 500, 8000)
+	f.setProcess(100, 1, 50, 20, 1234)
+	f.setProcess(101, 100, 5, 1, 1240)
+	f.set("100/task/100/children", "101 ")
+	f.set("101/task/101/children", "")
+	return f
+}
+
+func (f procFixture) set(name, contents string) {
+	f[name] = &fstest.MapFile{Data: []byte(contents)}
+}
+
+// setCPU sets the system-wide and per-CPU times, the work being split
+// evenly between two CPUs.
+func (f procFixture) setCPU(user, system, idle uint64) {
+	f.set("stat", fmt.Sprintf(`cpu  %d 0 %d %d 0 0 0 0 0 0
+cpu0 %d 0 %d %d 0 0 0 0 0 0
+cpu1 %d 0 %d %d 0 0 0 0 0 0
+btime 1062191376
+`, user, system, idle, user/2, system/2, idle/2, user/2, system/2, idle/2))
+}
+
+func (f procFixture) setProcess(pid, ppid int, utime, stime, start uint64) {
+	stat := fmt.Sprintf("%d (fixture) S %d %d %d 0 0 4194560 100 0 0 0 %d %d 0 0 20 0 1 0 %d 12144640 534 18446744073709551615 4194304 4729572 140730058798800 140730058796792 139881841869352 0 0 2637828 2 0 0 0 17 1 0 0 0 0 0\n",
+		pid, ppid, pid, pid, utime, stime, start)
+	f.set(fmt.Sprintf("%d/stat", pid), stat)
+	f.set(fmt.Sprintf("%d/task/%d/stat", pid, pid), stat)
+	f.set(fmt.Sprintf("%d/statm", pid), "2968 534 446 32 0 115 0\n")
+	f.set(fmt.Sprintf("%d/status", pid), "Name:\tfixture\nVmPeak:\t   11860 kB\nVmHWM:\t    2136 kB\n")
+	f.set(fmt.Sprintf("%d/io", pid), fmt.Sprintf(`rchar: %d
+wchar: 0
+syscr: 0
+syscw: 0
+read_bytes: %d
+write_bytes: 0
+cancelled_write_bytes: 0
+`, utime*10, utime*4096))
+}
+
+func (f procFixture) remove(pid int) {
+	for name := range f {
+		if strings.HasPrefix(name, fmt.Sprintf("%d/", pid)) {
+			delete(f, name)
+		}
+	}
+}
+
+// fixtureMonitor returns a monitor of 100 which has taken its first
+// sample, without starting it.
+func fixtureMonitor(t *testing.T, f procFixture, opts Options) *Monitor {
+	if opts.Proc == nil {
+		opts.Proc = fstest.MapFS(f)
+	}
+	opts, err := opts.withDefaults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := newMonitor(100, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys, err := m.fetchSystem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.begin(sys); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func fixtureTick(m *Monitor) (Measure, error) {
+	sys, err := m.fetchSystem()
+	if err != nil {
+		return Measure{}, err
+	}
+	return m.tick(sys)
+}
+
+func TestFixtureTick(t *testing.T) {
+	f := newProcFixture()
+	m := fixtureMonitor(t, f, Options{Threads: true})
+	f.setCPU(1100, 540, 8060)
+	f.setProcess(100, 1, 80, 30, 1234)
+	measure, err := fixtureTick(m)
+	if !assert.NoError(t, err) {
+		return
+	}
+	rss := 534 * uint64(os.Getpagesize())
+	measure.Elapsed = 0
+	assert.Equal(t, Measure{
+		PID:         100,
+		User:        30,
+		System:      10,
+		UserTotal:   100,
+		SystemTotal: 40,
+		IdleTotal:   60,
+		CPU:         CPUTimes{User: 100, System: 40, Idle: 60},
+		Cores: []CoreMeasure{
+			{0, CPUTimes{User: 50, System: 20, Idle: 30}},
+			{1, CPUTimes{User: 50, System: 20, Idle: 30}},
+		},
+		Processor: 1,
+		Memory:    rss / 1024,
+		MemoryStats: MemoryStats{
+			VSZ:    2968 * uint64(os.Getpagesize()),
+			RSS:    rss,
+			Shared: 446 * uint64(os.Getpagesize()),
+			Text:   32 * uint64(os.Getpagesize()),
+			Data:   115 * uint64(os.Getpagesize()),
+			HWM:    2136 * 1024,
+			Peak:   11860 * 1024,
+		},
+		Processes: 1,
+		Threads:   []ThreadMeasure{{100, "fixture", 30, 10}},
+		IO:        &IOStats{RChar: 300, ReadBytes: 30 * 4096},
+	}, measure)
+	assert.Equal(t, 15.0, measure.UserPerc())
+	assert.Equal(t, 5.0, measure.SysPerc())
+}
+
+func TestFixtureThreadsUnreadable(t *testing.T) {
+	f := newProcFixture()
+	task := f["100/task/100/stat"]
+	delete(f, "100/task/100/stat")
+	delete(f, "100/task/100/children")
+	m := fixtureMonitor(t, f, Options{Threads: true})
+
+	// the breakdown starts once the threads can be read
+	f["100/task/100/stat"] = task
+	measure, err := fixtureTick(m)
+	if assert.NoError(t, err) {
+		assert.Nil(t, measure.Threads)
+	}
+	f.setProcess(100, 1, 80, 30, 1234)
+	measure, err = fixtureTick(m)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []ThreadMeasure{{100, "fixture", 30, 10}}, measure.Threads)
+	}
+}
+
+func TestFixtureTree(t *testing.T) {
+	f := newProcFixture()
+	m := fixtureMonitor(t, f, Options{Tree: true})
+	f.setProcess(100, 1, 60, 20, 1234)
+	f.setProcess(101, 100, 15, 6, 1240)
+	measure, err := fixtureTick(m)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, measure.Processes)
+		assert.Equal(t, uint64(20), measure.User)
+		assert.Equal(t, uint64(5), measure.System)
+		assert.Equal(t, 2*534*uint64(os.Getpagesize()), measure.MemoryStats.RSS)
+	}
+
+	// without CONFIG_PROC_CHILDREN, children are found by ppid
+	delete(f, "100/task/100/children")
+	delete(f, "101/task/101/children")
+	measure, err = fixtureTick(m)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, measure.Processes)
+	}
+}
+
+// failingFS is a fixture in which reading some files fails, as
+// reading a process which was reaped after being opened does.
+type failingFS struct {
+	proc fs.FS
+	fail map[string]error
+}
+
+func (f failingFS) Open(name string) (fs.File, error) {
+	file, err := f.proc.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := f.fail[name]; ok {
+		return failingFile{file, err}, nil
+	}
+	return file, nil
+}
+
+type failingFile struct {
+	fs.File
+	err error
+}
+
+func (f failingFile) Read([]byte) (int, error) {
+	return 0, f.err
+}
+
+func TestFixtureTreeUnrelatedExit(t *testing.T) {
+	// without CONFIG_PROC_CHILDREN every process is read, and one
+	// nothing to do with 100 is reaped as it is
+	f := newProcFixture()
+	delete(f, "100/task/100/children")
+	delete(f, "101/task/101/children")
+	f.setProcess(555, 1, 5, 5, 900)
+	proc := failingFS{fstest.MapFS(f), map[string]error{}}
+	m := fixtureMonitor(t, f, Options{Tree: true, Proc: proc})
+	proc.fail["555/stat"] = syscall.ESRCH
+	measure, err := fixtureTick(m)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, measure.Processes)
+	}
+	// or holds something which can't be parsed
+	delete(proc.fail, "555/stat")
+	f.set("555/stat", "555 (broken")
+	measure, err = fixtureTick(m)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, measure.Processes)
+	}
+}
+
+func TestFixtureExited(t *testing.T) {
+	f := newProcFixture()
+	m := fixtureMonitor(t, f, Options{})
+	f.remove(100)
+	_, err := fixtureTick(m)
+	assert.Equal(t, ErrProcessExited, err)
+}
+
+func TestFixtureReplaced(t *testing.T) {
+	f := newProcFixture()
+	m := fixtureMonitor(t, f, Options{})
+	f.setProcess(100, 1, 1, 1, 5678)
+	_, err := fixtureTick(m)
+	assert.Equal(t, ErrProcessReplaced, err)
+}
+
+func TestFixtureParseError(t *testing.T) {
+	f := newProcFixture()
+	m := fixtureMonitor(t, f, Options{})
+	f.set("100/stat", "100 (fixture) S 1\n")
+	_, err := fixtureTick(m)
+	if assert.IsType(t, &ParseError{}, err) {
+		assert.Equal(t, "100/stat", err.(*ParseError).Path)
+	}
+}
+
+func TestFixtureScan(t *testing.T) {
+	f := newProcFixture()
+	f.set("100/cmdline", "fixture\x00--serve\x00")
+	f.set("101/cmdline", "")
+	f["100"] = &fstest.MapFile{Mode: fs.ModeDir | 0555, Sys: &syscall.Stat_t{Uid: 0}}
+	scan, err := NewScan(fstest.MapFS(f))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []int{100, 101}, pids(scan.Since(scan)))
+	parent := scan.processes[100].info
+	assert.Equal(t, "fixture --serve", parent.Cmdline)
+	assert.Equal(t, "root", parent.User)
+	assert.Equal(t, uint64(534*os.Getpagesize()), parent.RSS)
+	assert.Equal(t, 100, scan.processes[101].info.PPID)
+	// a plain fs.FS doesn't say who owns 101
+	assert.Empty(t, scan.processes[101].info.User)
+}
+
+func TestFakeClock(t *testing.T) {
+	f := newProcFixture()
+	c := clock.NewFake(time.Unix(1000, 0))
+	out := make(chan Measure, 1)
+	m, err := NewWithContext(context.Background(), out, 100, Options{Interval: time.Second, Proc: fstest.MapFS(f), Clock: c})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// the first sample was taken by NewWithContext, so the monitor
+	// only reads the fixture when the clock ticks
+	f.setCPU(1100, 540, 8060)
+	f.setProcess(100, 1, 80, 30, 1234)
+	c.Advance(time.Second)
+	measure := <-out
+	assert.Equal(t, time.Second, measure.Elapsed)
+	assert.Equal(t, uint64(30), measure.User)
+	assert.Equal(t, 30.0, measure.ReadRate()/4096)
+
+	// the fixture is only read again once the new interval is up
+	f.setProcess(100, 1, 95, 30, 1234)
+	assert.NoError(t, m.SetInterval(time.Minute))
+	c.Advance(time.Second)
+	select {
+	case <-out:
+		t.Fatal("ticked before new interval")
+	default:
+	}
+	c.Advance(59 * time.Second)
+	measure = <-out
+	assert.Equal(t, time.Minute, measure.Elapsed)
+	assert.Equal(t, uint64(15), measure.User)
+
+	f.remove(100)
+	c.Advance(time.Minute)
+	_, ok := <-out
+	assert.False(t, ok)
+	// known as soon as Output is closed
+	assert.Equal(t, ErrProcessExited, m.Err())
+	assert.Equal(t, &Exit{Cause: Exited}, m.Exit())
+	assert.Equal(t, 0, c.Waiters())
+}
+
+func TestFakeClockStop(t *testing.T) {
+	f := newProcFixture()
+	c := clock.NewFake(time.Unix(1000, 0))
+	out := make(chan Measure)
+	m, err := NewWithContext(context.Background(), out, 100, Options{Interval: time.Second, Proc: fstest.MapFS(f), Clock: c, Policy: BlockWhenFull})
+	if !assert.NoError(t, err) {
+		return
+	}
+	// nobody is reading, so the monitor blocks
+	c.Advance(time.Second)
+	m.Stop()
+	_, ok := <-out
+	assert.False(t, ok)
+	assert.Equal(t, context.Canceled, m.Err())
+}
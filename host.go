@@ -0,0 +1,77 @@
+package procmon
+
+import "math"
+
+// HostMemory is the memory of the whole system, from /proc/meminfo,
+// in bytes.
+type HostMemory struct {
+	Total uint64
+	// Available estimates how much could be allocated without
+	// swapping, counting reclaimable caches.
+	Available uint64
+	Cached    uint64
+	// Dirty is waiting to be written back to storage.
+	Dirty     uint64
+	SwapTotal uint64
+	SwapFree  uint64
+}
+
+// LoadAvg is the system load average, from /proc/loadavg.
+type LoadAvg struct {
+	Load1  float64
+	Load5  float64
+	Load15 float64
+	// Running is the number of runnable tasks
+	Running int
+	// Tasks is the number of tasks in existence
+	Tasks int
+}
+
+// HostStats describes the whole system the monitored process runs on.
+type HostStats struct {
+	Memory HostMemory
+	Load   LoadAvg
+	// MajorFaults is the number of page faults which needed storage
+	// I/O during the interval, across all processes.
+	MajorFaults uint64
+	// OOMKills is the number of processes killed by the OOM killer
+	// during the interval.  It is always zero before Linux 4.13.
+	OOMKills uint64
+}
+
+// The counters read from /proc/vmstat
+type vmstat struct {
+	majorFaults uint64
+	oomKills    uint64
+}
+
+// What is read about the whole system at one point in time
+type hostSample struct {
+	memory HostMemory
+	load   LoadAvg
+	vm     vmstat
+}
+
+// hostDelta measures the whole system between two samples, or
+// returns nil if either is missing.
+func hostDelta(old, new *hostSample) *HostStats {
+	if old == nil || new == nil {
+		return nil
+	}
+	return &HostStats{
+		Memory:      new.memory,
+		Load:        new.load,
+		MajorFaults: new.vm.majorFaults - old.vm.majorFaults,
+		OOMKills:    new.vm.oomKills - old.vm.oomKills,
+	}
+}
+
+// MemoryPerc calculates the resident set size of the monitored
+// process as a percentage of the system's memory, or NaN if host
+// stats weren't read.
+func (m *Measure) MemoryPerc() float64 {
+	if m.Host == nil || m.Host.Memory.Total == 0 {
+		return math.NaN()
+	}
+	return 100.0 * float64(m.MemoryStats.RSS) / float64(m.Host.Memory.Total)
+}
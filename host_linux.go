@@ -0,0 +1,83 @@
+// +build linux
+
+package procmon
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+)
+
+func parseMeminfo(in io.Reader) (HostMemory, error) {
+	var memory HostMemory
+	err := parseKB(in, map[string]*uint64{
+		"MemTotal":     &memory.Total,
+		"MemAvailable": &memory.Available,
+		"Cached":       &memory.Cached,
+		"Dirty":        &memory.Dirty,
+		"SwapTotal":    &memory.SwapTotal,
+		"SwapFree":     &memory.SwapFree,
+	})
+	if err == nil && memory.Total == 0 {
+		err = fmt.Errorf("No MemTotal")
+	}
+	return memory, err
+}
+
+func parseLoadAvg(in io.Reader) (LoadAvg, error) {
+	// e.g. "0.20 0.18 0.12 1/80 11206", the last being the most
+	// recently allocated pid
+	var load LoadAvg
+	var last int
+	_, err := fmt.Fscanf(in, "%f %f %f %d/%d %d", &load.Load1, &load.Load5, &load.Load15, &load.Running, &load.Tasks, &last)
+	return load, err
+}
+
+func parseVMStat(in io.Reader) (vmstat, error) {
+	// the same "key value" layout as meminfo, without the colons
+	// and units
+	var vm vmstat
+	err := parseKB(in, map[string]*uint64{
+		"pgmajfault": &vm.majorFaults,
+		"oom_kill":   &vm.oomKills,
+	})
+	return vm, err
+}
+
+func readProcFile(proc fs.FS, path string, parse func(io.Reader) error) error {
+	file, err := proc.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := parse(file); err != nil {
+		return &ParseError{path, err}
+	}
+	return nil
+}
+
+func fetchHostStats(proc fs.FS) (*hostSample, error) {
+	var host hostSample
+	err := readProcFile(proc, "meminfo", func(in io.Reader) (err error) {
+		host.memory, err = parseMeminfo(in)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = readProcFile(proc, "loadavg", func(in io.Reader) (err error) {
+		host.load, err = parseLoadAvg(in)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = readProcFile(proc, "vmstat", func(in io.Reader) (err error) {
+		host.vm, err = parseVMStat(in)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &host, nil
+}
@@ -0,0 +1,9 @@
+// +build !linux
+
+package procmon
+
+import "io/fs"
+
+func fetchHostStats(proc fs.FS) (*hostSample, error) {
+	return nil, errNotSupported
+}
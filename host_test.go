@@ -0,0 +1,93 @@
+// +build linux
+
+package procmon
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMeminfo(t *testing.T) {
+	memory, err := parseMeminfo(strings.NewReader(`MemTotal:       16310372 kB
+MemFree:         1283224 kB
+MemAvailable:    9871744 kB
+Buffers:          512252 kB
+Cached:          8120188 kB
+SwapCached:        10304 kB
+Active:          7655600 kB
+Dirty:               844 kB
+Writeback:             0 kB
+SwapTotal:       2097148 kB
+SwapFree:        1983228 kB
+HugePages_Total:       0
+Hugepagesize:       2048 kB
+`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, HostMemory{
+			16310372 * 1024,
+			9871744 * 1024,
+			8120188 * 1024,
+			844 * 1024,
+			2097148 * 1024,
+			1983228 * 1024,
+		}, memory)
+	}
+	_, err = parseMeminfo(strings.NewReader("MemFree:         1283224 kB\n"))
+	assert.Error(t, err)
+}
+
+func TestLoadAvg(t *testing.T) {
+	load, err := parseLoadAvg(strings.NewReader("0.20 0.18 0.12 1/80 11206\n"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, LoadAvg{0.2, 0.18, 0.12, 1, 80}, load)
+	}
+	_, err = parseLoadAvg(strings.NewReader("0.20 0.18 0.12\n"))
+	assert.Error(t, err)
+}
+
+func TestVMStat(t *testing.T) {
+	vm, err := parseVMStat(strings.NewReader(`nr_free_pages 320806
+pgfault 1934883012
+pgmajfault 104922
+oom_kill 3
+`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, vmstat{104922, 3}, vm)
+	}
+}
+
+func TestHostDelta(t *testing.T) {
+	old := &hostSample{HostMemory{Total: 100}, LoadAvg{Load1: 1}, vmstat{100, 1}}
+	new := &hostSample{HostMemory{Total: 100, Available: 50}, LoadAvg{Load1: 2}, vmstat{150, 3}}
+	assert.Nil(t, hostDelta(nil, new))
+	assert.Nil(t, hostDelta(old, nil))
+	host := hostDelta(old, new)
+	assert.Equal(t, &HostStats{HostMemory{Total: 100, Available: 50}, LoadAvg{Load1: 2}, 50, 2}, host)
+
+	m := Measure{MemoryStats: MemoryStats{RSS: 25}, Host: host}
+	assert.Equal(t, 25.0, m.MemoryPerc())
+	m.Host = nil
+	assert.True(t, math.IsNaN(m.MemoryPerc()))
+}
+
+func TestHostStats(t *testing.T) {
+	proc := fstest.MapFS{
+		"meminfo": &fstest.MapFile{Data: []byte("MemTotal:       16310372 kB\nMemAvailable:    9871744 kB\nDirty:               844 kB\n")},
+		"loadavg": &fstest.MapFile{Data: []byte("0.20 0.18 0.12 1/80 11206\n")},
+		"vmstat":  &fstest.MapFile{Data: []byte("pgmajfault 104922\noom_kill 3\n")},
+	}
+	host, err := fetchHostStats(proc)
+	if assert.NoError(t, err) {
+		assert.Equal(t, &hostSample{
+			HostMemory{Total: 16310372 * 1024, Available: 9871744 * 1024, Dirty: 844 * 1024},
+			LoadAvg{0.2, 0.18, 0.12, 1, 80},
+			vmstat{104922, 3},
+		}, host)
+	}
+	delete(proc, "vmstat")
+	_, err = fetchHostStats(proc)
+	assert.Error(t, err)
+}
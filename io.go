@@ -0,0 +1,118 @@
+package procmon
+
+import "math"
+
+// IOStats counts the I/O performed by a process, as reported by
+// /proc/<pid>/io.
+type IOStats struct {
+	// RChar is the number of bytes passed to read(2) and similar,
+	// whether or not they came from disk.
+	RChar uint64
+	// WChar is the number of bytes passed to write(2) and similar.
+	WChar uint64
+	// SyscR is the number of read system calls.
+	SyscR uint64
+	// SyscW is the number of write system calls.
+	SyscW uint64
+	// ReadBytes is the number of bytes fetched from storage.
+	ReadBytes uint64
+	// WriteBytes is the number of bytes sent to storage.
+	WriteBytes uint64
+	// CancelledWriteBytes is the number of bytes the process caused
+	// not to be written, by truncating dirty pagecache.
+	CancelledWriteBytes uint64
+}
+
+func (s *IOStats) add(other IOStats) {
+	s.RChar += other.RChar
+	s.WChar += other.WChar
+	s.SyscR += other.SyscR
+	s.SyscW += other.SyscW
+	s.ReadBytes += other.ReadBytes
+	s.WriteBytes += other.WriteBytes
+	s.CancelledWriteBytes += other.CancelledWriteBytes
+}
+
+func (s *IOStats) sub(other IOStats) IOStats {
+	return IOStats{
+		s.RChar - other.RChar,
+		s.WChar - other.WChar,
+		s.SyscR - other.SyscR,
+		s.SyscW - other.SyscW,
+		s.ReadBytes - other.ReadBytes,
+		s.WriteBytes - other.WriteBytes,
+		s.CancelledWriteBytes - other.CancelledWriteBytes,
+	}
+}
+
+func (s *IOStats) before(other IOStats) bool {
+	return s.RChar <= other.RChar && s.WChar <= other.WChar &&
+		s.SyscR <= other.SyscR && s.SyscW <= other.SyscW &&
+		s.ReadBytes <= other.ReadBytes && s.WriteBytes <= other.WriteBytes &&
+		s.CancelledWriteBytes <= other.CancelledWriteBytes
+}
+
+// ioDelta sums the I/O performed between two samples by each process
+// whose I/O was read in both.  It returns nil if no process's I/O
+// could be read.
+func ioDelta(old, new map[int]IOStats) *IOStats {
+	if len(new) == 0 {
+		return nil
+	}
+	delta := &IOStats{}
+	for pid, now := range new {
+		// unlike CPU usage, a process whose I/O couldn't be read
+		// last time may have just become readable rather than
+		// just started, so its counters can't be taken as this
+		// interval's.
+		then, ok := old[pid]
+		if !ok || !then.before(now) {
+			continue
+		}
+		delta.add(now.sub(then))
+	}
+	return delta
+}
+
+func (m *Measure) perSecond(count uint64) float64 {
+	if m.Elapsed <= 0 {
+		return math.NaN()
+	}
+	return float64(count) / m.Elapsed.Seconds()
+}
+
+// ReadRate calculates the bytes per second read from storage, or NaN
+// if I/O accounting is unavailable.
+func (m *Measure) ReadRate() float64 {
+	if m.IO == nil {
+		return math.NaN()
+	}
+	return m.perSecond(m.IO.ReadBytes)
+}
+
+// WriteRate calculates the bytes per second written to storage, or
+// NaN if I/O accounting is unavailable.
+func (m *Measure) WriteRate() float64 {
+	if m.IO == nil {
+		return math.NaN()
+	}
+	return m.perSecond(m.IO.WriteBytes)
+}
+
+// SyscRRate calculates read system calls per second, or NaN if I/O
+// accounting is unavailable.
+func (m *Measure) SyscRRate() float64 {
+	if m.IO == nil {
+		return math.NaN()
+	}
+	return m.perSecond(m.IO.SyscR)
+}
+
+// SyscWRate calculates write system calls per second, or NaN if I/O
+// accounting is unavailable.
+func (m *Measure) SyscWRate() float64 {
+	if m.IO == nil {
+		return math.NaN()
+	}
+	return m.perSecond(m.IO.SyscW)
+}
@@ -0,0 +1,26 @@
+package procmon
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestIODelta(t *testing.T) {
+	old := map[int]IOStats{1: {100, 100, 10, 10, 4096, 8192, 0}, 2: {50, 50, 5, 5, 0, 0, 0}}
+	new := map[int]IOStats{1: {150, 300, 15, 20, 8192, 8192, 0}, 3: {10, 0, 1, 0, 0, 0, 0}}
+	// 3 is left out until there's a sample to measure it against
+	assert.Equal(t, &IOStats{50, 200, 5, 10, 4096, 0, 0}, ioDelta(old, new))
+	assert.Nil(t, ioDelta(old, map[int]IOStats{}))
+}
+
+func TestIORates(t *testing.T) {
+	m := Measure{IO: &IOStats{ReadBytes: 4096, WriteBytes: 1024, SyscR: 10, SyscW: 20}, Elapsed: 2 * time.Second}
+	assert.Equal(t, 2048.0, m.ReadRate())
+	assert.Equal(t, 512.0, m.WriteRate())
+	assert.Equal(t, 5.0, m.SyscRRate())
+	assert.Equal(t, 10.0, m.SyscWRate())
+	m.IO = nil
+	assert.True(t, math.IsNaN(m.ReadRate()))
+}
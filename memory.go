@@ -0,0 +1,44 @@
+package procmon
+
+// MemoryStats is a breakdown of a process's memory use, in bytes.
+// When monitoring a process tree each field is summed across the
+// tree, which double counts shared pages and makes the peaks an upper
+// bound rather than a true peak.
+type MemoryStats struct {
+	// VSZ is the size of the virtual address space
+	VSZ uint64
+	// RSS is the resident set size
+	RSS uint64
+	// Shared is the part of RSS backed by files
+	Shared uint64
+	// Text is the size of the program's code
+	Text uint64
+	// Data is the size of data and stack
+	Data uint64
+	// PSS is RSS with shared pages divided between the processes
+	// sharing them.  Only read when DetailedMemory is set.
+	PSS uint64
+	// USS is the memory private to the process, which would be freed
+	// if it exited.  Only read when DetailedMemory is set.
+	USS uint64
+	// Swap is the amount swapped out.  Only read when DetailedMemory
+	// is set.
+	Swap uint64
+	// HWM is the peak RSS
+	HWM uint64
+	// Peak is the peak VSZ
+	Peak uint64
+}
+
+func (s *MemoryStats) add(other MemoryStats) {
+	s.VSZ += other.VSZ
+	s.RSS += other.RSS
+	s.Shared += other.Shared
+	s.Text += other.Text
+	s.Data += other.Data
+	s.PSS += other.PSS
+	s.USS += other.USS
+	s.Swap += other.Swap
+	s.HWM += other.HWM
+	s.Peak += other.Peak
+}
@@ -0,0 +1,225 @@
+package procmon
+
+import (
+	"context"
+	"fmt"
+	"github.com/meteor/procmon/clock"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Batch is the result of sampling every process in a Pool at once.
+// All of its measures share the same system-wide CPU figures, so
+// their percentages can be compared directly.
+type Batch struct {
+	// At is when the system-wide CPU figures were read.
+	At time.Time
+	// Measures holds one measure per process, ordered by PID.
+	Measures []Measure
+	// Stopped holds the processes which could no longer be monitored
+	// and have been removed from the pool, with the reason, as Err
+	// would give for a Monitor.
+	Stopped map[int]error
+}
+
+// Pool monitors many processes with a single goroutine, reading the
+// system-wide CPU figures, and the host's stats and pressure if asked
+// for, once per sample rather than once per process.  Output is
+// closed once the pool stops.
+type Pool struct {
+	Output chan<- Batch
+	opts   Options
+	logger *log.Entry
+	ticker clock.Ticker
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	// finished is closed once err is set, before Output is closed
+	finished chan struct{}
+	err      error
+	lock     sync.Mutex
+	members  map[int]*Monitor
+	pending  map[int]*Monitor
+}
+
+// NewPool creates an empty pool configured by opts and starts it.  The
+// pool stops when ctx is done.
+func NewPool(ctx context.Context, out chan<- Batch, opts Options) (*Pool, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	p := &Pool{
+		Output:   out,
+		opts:     opts,
+		logger:   opts.Logger.WithFields(log.Fields{}),
+		ticker:   opts.Clock.NewTicker(opts.Interval),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+		members:  make(map[int]*Monitor),
+		pending:  make(map[int]*Monitor),
+	}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	go p.run()
+	return p, nil
+}
+
+// Add starts monitoring process.  Its first measure is in the batch
+// after next, as the next sample is its starting point.
+func (p *Pool) Add(process int) error {
+	m, err := newMonitor(process, p.opts)
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.members[process] != nil || p.pending[process] != nil {
+		return fmt.Errorf("Process %d is already in the pool", process)
+	}
+	p.pending[process] = m
+	return nil
+}
+
+// Remove stops monitoring process.
+func (p *Pool) Remove(process int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.members, process)
+	delete(p.pending, process)
+}
+
+// Len returns the number of processes being monitored.
+func (p *Pool) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.members) + len(p.pending)
+}
+
+func (p *Pool) run() {
+	defer close(p.done)
+	defer close(p.Output)
+	defer p.ticker.Stop()
+	p.err = p.loop()
+	close(p.finished)
+}
+
+func (p *Pool) loop() error {
+	for {
+		select {
+		case <-p.ticker.C():
+			batch, err := p.sample()
+			if err != nil {
+				p.logger.WithError(err).Error("couldn't read total CPU stats")
+				return err
+			}
+			if p.opts.Policy == BlockWhenFull {
+				select {
+				case p.Output <- batch:
+				case <-p.ctx.Done():
+					return p.ctx.Err()
+				}
+				continue
+			}
+			select {
+			case p.Output <- batch:
+			default:
+				p.logger.Warn("Output full, dropping update")
+			}
+		case <-p.ctx.Done():
+			return p.ctx.Err()
+		}
+	}
+}
+
+// The outcome of sampling one process in a batch
+type sampled struct {
+	m       *Monitor
+	measure Measure
+	err     error
+}
+
+// sample reads the system-wide figures once, then measures every
+// member against them and starts every pending process from them.
+// The processes are read without holding the lock, so Add and Remove
+// don't wait on /proc; one removed meanwhile is left out of the batch.
+func (p *Pool) sample() (Batch, error) {
+	batch := Batch{At: p.opts.Clock.Now(), Stopped: make(map[int]error)}
+	sys, err := fetchSystem(p.opts.Proc, p.opts.Host, p.opts.Pressure, p.logger)
+	if err != nil {
+		return Batch{}, err
+	}
+	ticked := make(map[int]sampled)
+	begun := make(map[int]sampled)
+	p.lock.Lock()
+	for pid, m := range p.members {
+		ticked[pid] = sampled{m: m}
+	}
+	for pid, m := range p.pending {
+		begun[pid] = sampled{m: m}
+	}
+	p.lock.Unlock()
+
+	for pid, s := range ticked {
+		s.measure, s.err = s.m.tick(sys)
+		ticked[pid] = s
+	}
+	for pid, s := range begun {
+		s.err = s.m.begin(sys)
+		begun[pid] = s
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for pid, s := range ticked {
+		if p.members[pid] != s.m {
+			continue
+		}
+		if s.err != nil {
+			batch.Stopped[pid] = s.err
+			delete(p.members, pid)
+			continue
+		}
+		batch.Measures = append(batch.Measures, s.measure)
+	}
+	for pid, s := range begun {
+		if p.pending[pid] != s.m {
+			continue
+		}
+		delete(p.pending, pid)
+		if s.err != nil {
+			batch.Stopped[pid] = s.err
+			continue
+		}
+		p.members[pid] = s.m
+	}
+	sort.Slice(batch.Measures, func(i, j int) bool { return batch.Measures[i].PID < batch.Measures[j].PID })
+	return batch, nil
+}
+
+// Stop halts the pool and waits for it to finish, after which Output
+// is closed.  It is safe to call more than once.
+func (p *Pool) Stop() {
+	p.cancel()
+	<-p.done
+}
+
+// Wait blocks until the pool stops and returns the reason, as Err
+// does.
+func (p *Pool) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// Err returns nil while the pool is running.  Once it has stopped, it
+// returns context.Canceled if Stop was called, the context's error if
+// it was done, or whatever prevented reading the system-wide figures.
+func (p *Pool) Err() error {
+	select {
+	case <-p.finished:
+		return p.err
+	default:
+		return nil
+	}
+}
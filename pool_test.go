@@ -0,0 +1,109 @@
+// +build linux
+
+package procmon
+
+import (
+	"context"
+	"github.com/meteor/procmon/clock"
+	"github.com/stretchr/testify/assert"
+	"io/fs"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	out := make(chan Batch)
+	p, err := NewPool(context.Background(), out, Options{Interval: time.Millisecond, Policy: BlockWhenFull})
+	if !assert.NoError(t, err) {
+		return
+	}
+	first, second := startSleep(t), startSleep(t)
+	defer second.Process.Kill()
+	assert.NoError(t, p.Add(first.Process.Pid))
+	assert.NoError(t, p.Add(second.Process.Pid))
+	assert.Error(t, p.Add(second.Process.Pid))
+	assert.Error(t, p.Add(-1))
+	assert.Equal(t, 2, p.Len())
+
+	// the first batch after adding is only a starting point, and
+	// the two adds may straddle a tick
+	var batch Batch
+	for len(batch.Measures) < 2 {
+		batch = <-out
+	}
+	if assert.Len(t, batch.Measures, 2) {
+		assert.Equal(t, batch.Measures[0].Total(), batch.Measures[1].Total())
+		assert.Equal(t, batch.Measures[0].CPU, batch.Measures[1].CPU)
+	}
+
+	first.Process.Kill()
+	first.Wait()
+	for len(batch.Stopped) == 0 {
+		batch = <-out
+	}
+	assert.Equal(t, map[int]error{first.Process.Pid: ErrProcessExited}, batch.Stopped)
+	assert.Equal(t, 1, p.Len())
+
+	p.Remove(second.Process.Pid)
+	assert.Equal(t, 0, p.Len())
+	assert.NoError(t, p.Err())
+	p.Stop()
+	p.Stop()
+	_, ok := <-out
+	assert.False(t, ok)
+	assert.Equal(t, context.Canceled, p.Err())
+	assert.Equal(t, context.Canceled, p.Wait())
+}
+
+// countingFS counts how often each file of a fixture is opened.
+type countingFS struct {
+	proc   fs.FS
+	lock   sync.Mutex
+	opened map[string]int
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	c.lock.Lock()
+	c.opened[name]++
+	c.lock.Unlock()
+	return c.proc.Open(name)
+}
+
+func (c *countingFS) count(name string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.opened[name]
+}
+
+func TestPoolSharesHost(t *testing.T) {
+	f := newProcFixture()
+	f.set("meminfo", "MemTotal:       16314952 kB\nMemAvailable:   11286332 kB\n")
+	f.set("loadavg", "0.52 0.58 0.59 2/1136 24817\n")
+	f.set("vmstat", "pgmajfault 1022\noom_kill 0\n")
+	f.set("pressure/cpu", "some avg10=0.00 avg60=0.00 avg300=0.00 total=1000\n")
+	f.set("pressure/memory", "some avg10=0.00 avg60=0.00 avg300=0.00 total=0\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n")
+	f.set("pressure/io", "some avg10=0.00 avg60=0.00 avg300=0.00 total=0\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n")
+	proc := &countingFS{proc: fstest.MapFS(f), opened: make(map[string]int)}
+	c := clock.NewFake(time.Unix(1000, 0))
+	out := make(chan Batch)
+	p, err := NewPool(context.Background(), out, Options{Proc: proc, Clock: c, Host: true, Pressure: HostPressure, Policy: BlockWhenFull})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer p.Stop()
+	assert.NoError(t, p.Add(100))
+	assert.NoError(t, p.Add(101))
+	c.Advance(DefaultInterval)
+	<-out
+	c.Advance(DefaultInterval)
+	batch := <-out
+	if assert.Len(t, batch.Measures, 2) {
+		assert.NotNil(t, batch.Measures[0].Host)
+		assert.NotNil(t, batch.Measures[1].Pressure)
+	}
+	// once per batch, not once per process
+	assert.Equal(t, 2, proc.count("meminfo"))
+	assert.Equal(t, 2, proc.count("pressure/cpu"))
+}
@@ -0,0 +1,177 @@
+package procmon
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/meteor/procmon/cgroup"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PressureScope says whose pressure stall information a Monitor
+// reads.
+type PressureScope int
+
+const (
+	// NoPressure leaves Measure.Pressure nil.
+	NoPressure PressureScope = iota
+	// HostPressure reads /proc/pressure, covering the whole system.
+	HostPressure
+	// CgroupPressure reads the pressure files of the monitored
+	// process's cgroup, which needs cgroup v2.
+	CgroupPressure
+)
+
+// PressureLine is one line of a pressure file: the percentage of time
+// some (or all) tasks were stalled waiting for a resource, averaged
+// over 10, 60 and 300 seconds, and the total stall time in
+// microseconds.
+type PressureLine struct {
+	Avg10  float64
+	Avg60  float64
+	Avg300 float64
+	Total  uint64
+}
+
+// Pressure is the stall information for a single resource.  Some
+// covers time in which at least one task was stalled, and Full time
+// in which all non-idle tasks were.  Full is zero for CPU on kernels
+// before 5.13, and always zero for CPU across the whole system.
+type Pressure struct {
+	Some PressureLine
+	Full PressureLine
+}
+
+// PressureStats is the stall information for each resource, as
+// described in the kernel's Documentation/accounting/psi.rst.
+type PressureStats struct {
+	CPU    Pressure
+	Memory Pressure
+	IO     Pressure
+}
+
+func parsePressure(in io.Reader) (Pressure, error) {
+	var result Pressure
+	s := bufio.NewScanner(in)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var line *PressureLine
+		switch fields[0] {
+		case "some":
+			line = &result.Some
+		case "full":
+			line = &result.Full
+		default:
+			return Pressure{}, fmt.Errorf("Unknown pressure line %q", s.Text())
+		}
+		found := 0
+		for _, field := range fields[1:] {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 {
+				return Pressure{}, fmt.Errorf("Malformed pressure field %q", field)
+			}
+			var err error
+			switch parts[0] {
+			case "avg10":
+				line.Avg10, err = strconv.ParseFloat(parts[1], 64)
+			case "avg60":
+				line.Avg60, err = strconv.ParseFloat(parts[1], 64)
+			case "avg300":
+				line.Avg300, err = strconv.ParseFloat(parts[1], 64)
+			case "total":
+				line.Total, err = strconv.ParseUint(parts[1], 10, 64)
+			default:
+				continue
+			}
+			if err != nil {
+				return Pressure{}, err
+			}
+			found++
+		}
+		if found != 4 {
+			return Pressure{}, fmt.Errorf("Incomplete pressure line %q", s.Text())
+		}
+	}
+	return result, s.Err()
+}
+
+func readPressure(dir fs.FS, paths [3]string) (*PressureStats, error) {
+	var stats PressureStats
+	for i, into := range []*Pressure{&stats.CPU, &stats.Memory, &stats.IO} {
+		file, err := dir.Open(paths[i])
+		if err != nil {
+			return nil, err
+		}
+		*into, err = parsePressure(file)
+		file.Close()
+		if err != nil {
+			return nil, &ParseError{paths[i], err}
+		}
+	}
+	return &stats, nil
+}
+
+// SystemPressure reads the stall information for the whole system.  It
+// fails if the kernel was built without PSI or booted with psi=0.
+func SystemPressure() (*PressureStats, error) {
+	return systemPressure(defaultProc)
+}
+
+func systemPressure(proc fs.FS) (*PressureStats, error) {
+	return readPressure(proc, [3]string{
+		"pressure/cpu",
+		"pressure/memory",
+		"pressure/io",
+	})
+}
+
+// GroupPressure reads the stall information for the tasks in group.
+func GroupPressure(group *cgroup.Group) (*PressureStats, error) {
+	return readPressure(os.DirFS(group.Dir()), [3]string{
+		"cpu.pressure",
+		"memory.pressure",
+		"io.pressure",
+	})
+}
+
+// fetchPressure reads the stall information for the process's cgroup.
+// The host's is read once per sample by fetchSystem instead.  Like the
+// quota, the group may be removed from under the process, so failing
+// to read it isn't worth stopping over.
+func (m *Monitor) fetchPressure() *PressureStats {
+	stats, err := GroupPressure(m.group)
+	if err != nil {
+		m.logger.WithError(err).Warn("couldn't read pressure stall information")
+		return nil
+	}
+	return stats
+}
+
+func (l PressureLine) sub(other PressureLine) PressureLine {
+	l.Total -= other.Total
+	return l
+}
+
+func (p Pressure) sub(other Pressure) Pressure {
+	return Pressure{p.Some.sub(other.Some), p.Full.sub(other.Full)}
+}
+
+// pressureDelta turns the totals into the stall time during the
+// interval, keeping the averages as of its end.  It returns nil if
+// either sample is missing.
+func pressureDelta(old, new *PressureStats) *PressureStats {
+	if old == nil || new == nil {
+		return nil
+	}
+	return &PressureStats{
+		new.CPU.sub(old.CPU),
+		new.Memory.sub(old.Memory),
+		new.IO.sub(old.IO),
+	}
+}
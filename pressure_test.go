@@ -0,0 +1,85 @@
+package procmon
+
+import (
+	"github.com/meteor/procmon/cgroup"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPressure(t *testing.T) {
+	pressure, err := parsePressure(strings.NewReader(`some avg10=1.53 avg60=0.87 avg300=0.28 total=5632173
+full avg10=0.00 avg60=0.13 avg300=0.06 total=1243012
+`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, Pressure{
+			PressureLine{1.53, 0.87, 0.28, 5632173},
+			PressureLine{0, 0.13, 0.06, 1243012},
+		}, pressure)
+	}
+}
+
+func TestPressureWithoutFull(t *testing.T) {
+	// CPU pressure before 5.13
+	pressure, err := parsePressure(strings.NewReader(`some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, Pressure{}, pressure)
+	}
+}
+
+func TestBrokenPressure(t *testing.T) {
+	for _, in := range []string{
+		"most avg10=0.00 avg60=0.00 avg300=0.00 total=0\n",
+		"some avg10=0.00 avg60=0.00 avg300=0.00\n",
+		"some avg10=0.00 avg60=0.00 avg300=0.00 total=-1\n",
+		"some avg10 avg60=0.00 avg300=0.00 total=0\n",
+	} {
+		_, err := parsePressure(strings.NewReader(in))
+		assert.Error(t, err, in)
+	}
+}
+
+func TestPressureDelta(t *testing.T) {
+	old := &PressureStats{IO: Pressure{PressureLine{5, 5, 5, 1000}, PressureLine{1, 1, 1, 400}}}
+	new := &PressureStats{IO: Pressure{PressureLine{7, 6, 5, 3500}, PressureLine{2, 1, 1, 900}}}
+	assert.Nil(t, pressureDelta(nil, new))
+	assert.Nil(t, pressureDelta(old, nil))
+	assert.Equal(t, &PressureStats{IO: Pressure{PressureLine{7, 6, 5, 2500}, PressureLine{2, 1, 1, 500}}}, pressureDelta(old, new))
+}
+
+func TestGroupPressure(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	group := &cgroup.Group{Root: root, Path: "/test.slice"}
+	os.MkdirAll(group.Dir(), 0755)
+	files := map[string]string{
+		"cpu.pressure":    "some avg10=4.00 avg60=2.00 avg300=1.00 total=300\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n",
+		"memory.pressure": "some avg10=0.00 avg60=0.00 avg300=0.00 total=20\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=10\n",
+	}
+	for name, contents := range files {
+		ioutil.WriteFile(filepath.Join(group.Dir(), name), []byte(contents), 0644)
+	}
+	_, err = GroupPressure(group)
+	assert.True(t, os.IsNotExist(err))
+
+	ioutil.WriteFile(filepath.Join(group.Dir(), "io.pressure"), []byte("some avg10=0.00 avg60=0.00 avg300=0.00 total=bad\n"), 0644)
+	_, err = GroupPressure(group)
+	assert.IsType(t, &ParseError{}, err)
+
+	ioutil.WriteFile(filepath.Join(group.Dir(), "io.pressure"), []byte("some avg10=0.00 avg60=0.00 avg300=0.00 total=7\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=5\n"), 0644)
+	stats, err := GroupPressure(group)
+	if assert.NoError(t, err) {
+		assert.Equal(t, &PressureStats{
+			CPU:    Pressure{Some: PressureLine{4, 2, 1, 300}},
+			Memory: Pressure{Some: PressureLine{Total: 20}, Full: PressureLine{Total: 10}},
+			IO:     Pressure{Some: PressureLine{Total: 7}, Full: PressureLine{Total: 5}},
+		}, stats)
+	}
+}
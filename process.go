@@ -3,12 +3,22 @@
 package procmon
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/meteor/procmon/cgroup"
+	"github.com/meteor/procmon/clock"
 	log "github.com/sirupsen/logrus"
+	"io/fs"
+	"os"
+	"syscall"
 	"time"
 )
 
 // Measure is a point in time measure of a process's resource consumption.
 type Measure struct {
+	// PID is the monitored process
+	PID int
 	// User is the number of usermode CPU jiffies used
 	User uint64
 	// System is the number of kernelmode CPU jiffies used
@@ -19,115 +29,501 @@ type Measure struct {
 	SystemTotal uint64
 	// IdleTotal is the number of CPU jiffies spent in the idle task.
 	IdleTotal uint64
-	// Memory is the amount of memory used in kB
+	// CPU breaks down the jiffies spent across all processes by
+	// state.  UserTotal is CPU.User plus CPU.Nice.
+	CPU CPUTimes
+	// Cores breaks CPU down by CPU, ordered by CPU number.  CPUs
+	// which went on or offline during the interval are left out.
+	Cores []CoreMeasure
+	// Processor is the CPU the monitored process last ran on.
+	Processor int
+	// Memory is the resident set size in kB
 	Memory uint64
+	// MemoryStats is a more detailed breakdown of memory use
+	MemoryStats MemoryStats
+	// Processes is the number of processes measured, which is more
+	// than one when monitoring a process tree.
+	Processes int
+	// Threads breaks User and System down by thread of the monitored
+	// process, if requested.  It is ordered by TID.
+	Threads []ThreadMeasure
+	// IO is the I/O performed, or nil if the kernel wouldn't tell us.
+	IO *IOStats
+	// Quota is the CPU limit of the process's cgroup and how often
+	// it was enforced, if requested and readable.
+	Quota *Quota
+	// Pressure is the pressure stall information, if requested and
+	// readable.  Each Total is the stall time during the interval,
+	// while the averages are as of its end.
+	Pressure *PressureStats
+	// Host describes the whole system, if requested and readable.
+	Host *HostStats
+	// Elapsed is the wall clock time covered by this measure.
+	Elapsed time.Duration
 }
 
 // Point in time measure of a process's state
 type point struct {
 	user   uint64
 	system uint64
-	idle   uint64
+	// start tells apart different processes which had the same pid
+	start uint64
+}
+
+// Everything read from the monitored processes at one point in time
+type sample struct {
+	at        time.Time
+	processor int
+	usage     map[int]point
+	io        map[int]IOStats
+	memory    MemoryStats
+	quota     *quotaSample
+	pressure  *PressureStats
+	host      *hostSample
+}
+
+// What is read about the whole system at one point in time, once
+// however many processes are sampled against it
+type system struct {
+	total    CPUTimes
+	cores    map[int]CPUTimes
+	host     *hostSample
+	pressure *PressureStats
+}
+
+// fetchSystem reads the CPU usage of the whole system and, if asked
+// to, its host stats and pressure.  Only the CPU usage is needed to
+// carry on: the rest are extras, so failing to read them is logged and
+// the sample goes without.
+func fetchSystem(proc fs.FS, host bool, psi PressureScope, logger *log.Entry) (system, error) {
+	var sys system
+	var err error
+	sys.total, sys.cores, err = fetchCPUUsage(proc)
+	if err != nil {
+		return system{}, err
+	}
+	if host {
+		sys.host, err = fetchHostStats(proc)
+		if err != nil {
+			logger.WithError(err).Warn("couldn't read host stats")
+		}
+	}
+	if psi == HostPressure {
+		sys.pressure, err = systemPressure(proc)
+		if err != nil {
+			logger.WithError(err).Warn("couldn't read pressure stall information")
+		}
+	}
+	return sys, nil
+}
+
+func (m *Monitor) fetchSystem() (system, error) {
+	return fetchSystem(m.proc, m.host, m.psi, m.logger)
+}
+
+// DefaultInterval is how often a Monitor samples unless told
+// otherwise.
+const DefaultInterval = 5 * time.Second
+
+// OutputPolicy decides what a Monitor does with a measure when its
+// Output channel is full.
+type OutputPolicy int
+
+const (
+	// DropWhenFull discards the measure and logs a warning.
+	DropWhenFull OutputPolicy = iota
+	// BlockWhenFull waits for the reader to catch up, delaying
+	// subsequent samples.
+	BlockWhenFull
+)
+
+// Options configure a Monitor.  The zero value gives the behaviour of
+// New.
+type Options struct {
+	// Interval is the time between samples; zero means
+	// DefaultInterval.
+	Interval time.Duration
+	// Logger receives the monitor's diagnostics; nil means the
+	// logrus standard logger.
+	Logger log.FieldLogger
+	// Policy decides what to do when Output is full.
+	Policy OutputPolicy
+	// Tree includes every descendant of the process in each
+	// measure, rediscovering them on every sample.
+	Tree bool
+	// Threads adds a per-thread breakdown of the monitored process
+	// to each measure.  Descendants are not broken down.
+	Threads bool
+	// DetailedMemory reads PSS, USS and swap usage, which is
+	// relatively expensive for processes with large address spaces.
+	DetailedMemory bool
+	// Quota reads the CPU quota of the process's cgroup, and how
+	// often the group was throttled for exceeding it.  It needs
+	// cgroup v2.
+	Quota bool
+	// Pressure says whose pressure stall information to read, if
+	// anyone's.
+	Pressure PressureScope
+	// Host reads the memory, load average and paging activity of
+	// the whole system.
+	Host bool
+	// Proc is the procfs to read, such as os.DirFS("/host/proc") in a
+	// container watching its host; nil means /proc.  Follow's
+	// selector reads it too.
+	Proc fs.FS
+	// Clock times the samples; nil means the system clock.  Tests
+	// use a clock.Fake to control when samples are taken.
+	Clock clock.Clock
+	// Kernel reads the kernel log from when monitoring starts, so
+	// that Exit can say whether the process was killed by the OOM
+	// killer or for a segfault.  It needs permission to read
+	// /dev/kmsg, and the kernel gives pids as seen by the host, so
+	// Proc must be the host's procfs.  Pools ignore it.
+	Kernel bool
+	// OnExit, if set, is called by Follow with how each process it
+	// was following went away, as Monitor.Exit would give it.  It
+	// may be called from several goroutines at once.
+	OnExit func(pid int, exit *Exit)
+}
+
+// The host's procfs
+var defaultProc = os.DirFS("/proc")
+
+// ErrProcessExited is the reason a Monitor stops when the monitored
+// process goes away.
+var ErrProcessExited = errors.New("Process exited")
+
+// ErrProcessReplaced is the reason a Monitor stops when the monitored
+// process has gone away and its pid has been given to another.
+var ErrProcessReplaced = errors.New("Process replaced by another with the same pid")
+
+// ParseError is the reason a Monitor stops when it can't make sense of
+// what the kernel told it.  Path is relative to the procfs read.
+type ParseError struct {
+	Path string
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("Couldn't parse %s: %v", e.Path, e.Err)
+}
+
+// Unwrap returns the underlying parser error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
 }
 
 // Monitor represents a continuous monitoring of a given Linux
-// process.
+// process.  Output is closed once monitoring ends, whether because of
+// Stop, cancellation of its context, or an error; Err then says which.
 type Monitor struct {
 	Output  chan<- Measure
-	ticker  *time.Ticker
+	ticker  clock.Ticker
+	clock   clock.Clock
+	proc    fs.FS
 	process int
-	done    chan bool
-	stats   point
-	total   point
+	start   uint64
+	policy  OutputPolicy
+	tree    bool
+	detail  bool
+	host    bool
+	psi     PressureScope
+	split   bool
+	threads map[int]thread
+	group   *cgroup.Group
+	kernel  kernelLog
+	tids    map[int]bool
+	exit    *Exit
+	logger  *log.Entry
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	// finished is closed once err and exit are set, before Output is
+	// closed, so that a caller which sees Output close sees them too
+	finished chan struct{}
+	err      error
+	stats    sample
+	total    CPUTimes
+	cores    map[int]CPUTimes
 }
 
-// New creates a new monitor and starts it.
+// New creates a new monitor sampling every DefaultInterval and starts
+// it.
 func New(out chan<- Measure, process int) (*Monitor, error) {
+	return NewWithOptions(out, process, Options{})
+}
+
+// NewWithOptions creates a new monitor configured by opts and starts
+// it.
+func NewWithOptions(out chan<- Measure, process int, opts Options) (*Monitor, error) {
+	return NewWithContext(context.Background(), out, process, opts)
+}
+
+// NewWithContext creates a new monitor configured by opts, takes the
+// first sample, which the first measure is taken against, and starts
+// it.  The monitor stops when ctx is done.
+func NewWithContext(ctx context.Context, out chan<- Measure, process int, opts Options) (*Monitor, error) {
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	m, err := newMonitor(process, opts)
+	if err != nil {
+		return nil, err
+	}
+	sys, err := m.fetchSystem()
+	if err != nil {
+		return nil, err
+	}
+	if err := m.begin(sys); err != nil {
+		return nil, err
+	}
+	if opts.Kernel {
+		if m.kernel, err = openKernelLog(); err != nil {
+			return nil, err
+		}
+		m.noteTasks()
+	}
+	m.Output = out
+	m.done = make(chan struct{})
+	m.finished = make(chan struct{})
+	m.ctx, m.cancel = context.WithCancel(ctx)
+	m.ticker = m.clock.NewTicker(opts.Interval)
+	go m.monitor()
+	return m, nil
+}
+
+func (opts Options) withDefaults() (Options, error) {
+	if opts.Interval < 0 {
+		return opts, fmt.Errorf("Negative sampling interval %v", opts.Interval)
+	}
+	if opts.Interval == 0 {
+		opts.Interval = DefaultInterval
+	}
+	if opts.Logger == nil {
+		opts.Logger = log.StandardLogger()
+	}
+	if opts.Proc == nil {
+		opts.Proc = defaultProc
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.Real
+	}
+	return opts, nil
+}
+
+// newMonitor checks that process can be monitored, without starting
+// to do so.
+func newMonitor(process int, opts Options) (*Monitor, error) {
 	m := new(Monitor)
-	m.ticker = time.NewTicker(5 * time.Second)
-	m.done = make(chan bool, 1)
+	m.proc = opts.Proc
+	m.clock = opts.Clock
 	m.process = process
-	m.Output = out
+	m.policy = opts.Policy
+	m.tree = opts.Tree
+	m.detail = opts.DetailedMemory
+	m.psi = opts.Pressure
+	m.host = opts.Host
+	m.split = opts.Threads
+	m.logger = opts.Logger.WithField("process", process)
 	if err := m.preflight(); err != nil {
 		return nil, err
 	}
-	go m.Monitor()
+	if opts.Quota || opts.Pressure == CgroupPressure {
+		var err error
+		if m.group, err = cgroup.ForProcessIn(m.proc, process); err != nil {
+			return nil, err
+		}
+	}
 	return m, nil
 }
 
-// Monitor runs in a background goroutine that can be halted with Stop
-// and monitors process metrics, submitting results to datadog every 5
-// seconds.
-func (m *Monitor) Monitor() {
-	var err error
-	m.stats, err = m.fetchProcessUsage()
-	if err != nil {
-		log.WithField("process", m.process).WithError(err).
-			Error("couldn't read process stats")
+// SetInterval changes the time between samples of a running monitor.
+// The next sample is taken interval from now.
+func (m *Monitor) SetInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("Non-positive sampling interval %v", interval)
 	}
-	m.total, err = m.fetchTotalUsage()
-	if err != nil {
-		log.WithField("process", m.process).WithError(err).
-			Error("couldn't read total CPU stats")
+	if err := m.Err(); err != nil {
+		return err
 	}
+	m.ticker.Reset(interval)
+	return nil
+}
+
+// monitor runs in a background goroutine that can be halted with Stop
+// and monitors process metrics, submitting results every sampling
+// interval.  NewWithContext starts it.
+func (m *Monitor) monitor() {
+	defer close(m.done)
+	defer close(m.Output)
+	defer m.ticker.Stop()
+	if m.kernel != nil {
+		defer m.kernel.Close()
+	}
+	m.err = m.run()
+	close(m.finished)
+}
+
+// stopped turns a failure to read something into the reason the
+// monitor stopped.
+func (m *Monitor) stopped(err error, what string) error {
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ESRCH) {
+		m.exit = exitOf(m.kernel, m.process, m.tids)
+		m.logger.WithError(err).WithField("cause", m.exit).Info("process exited")
+		return ErrProcessExited
+	}
+	if err == ErrProcessReplaced {
+		m.logger.Info("process replaced")
+		return err
+	}
+	m.logger.WithError(err).Error("couldn't read " + what)
+	return err
+}
+
+func (m *Monitor) run() error {
 	for {
 		select {
-		case <-m.ticker.C:
-			newtarget, err := m.fetchProcessUsage()
+		case <-m.ticker.C():
+			sys, err := m.fetchSystem()
 			if err != nil {
-				log.WithField("process", m.process).WithError(err).
-					Error("couldn't read process stats")
-				m.ticker.Stop()
-				close(m.Output)
-				return
+				// this is a weird one, as it indicates something has
+				// gone seriously haywire.  Still, closing as normal.
+				return m.stopped(err, "total CPU stats")
 			}
-			memory, err := m.fetchProcessMemory()
+			measure, err := m.tick(sys)
 			if err != nil {
-				log.WithField("process", m.process).WithError(err).
-					Error("couldn't read process stats")
-				m.ticker.Stop()
-				close(m.Output)
-				return
+				return err
 			}
-			newtotal, err := m.fetchTotalUsage()
-			if err != nil {
-				// this is a weird one, as it indicates something has
-				// gone seriously haywire.  Still, closing as normal.
-				log.WithField("process", m.process).WithError(err).
-					Error("couldn't read total CPU stats")
-				m.ticker.Stop()
-				close(m.Output)
-				return
+			if m.policy == BlockWhenFull {
+				select {
+				case m.Output <- measure:
+				case <-m.ctx.Done():
+					return m.ctx.Err()
+				}
+				continue
 			}
-
-			log.WithFields(log.Fields{
-				"new total":  newtotal,
-				"new target": newtarget,
-				"old total":  m.total,
-				"old target": m.stats,
-			}).Debug("tick")
 			select {
-			case m.Output <- Measure{
-				newtarget.user - m.stats.user,
-				newtarget.system - m.stats.system,
-				newtotal.user - m.total.user,
-				newtotal.system - m.total.system,
-				newtotal.idle - m.total.idle,
-				memory,
-			}:
+			case m.Output <- measure:
 			default:
-				log.WithField("process", m.process).Warn("Output full, dropping update")
+				m.logger.Warn("Output full, dropping update")
 			}
-			m.stats = newtarget
-			m.total = newtotal
-		case <-m.done:
-			return
+		case <-m.ctx.Done():
+			return m.ctx.Err()
 		}
 	}
 }
 
-// Stop halts the background monitoring task.
+// begin takes the first sample, which later ones are measured against,
+// given the whole system at about the same time.
+func (m *Monitor) begin(sys system) error {
+	var err error
+	m.stats, err = m.fetchProcesses(sys)
+	if err != nil {
+		return m.stopped(err, "process stats")
+	}
+	m.total, m.cores = sys.total, sys.cores
+	if m.split {
+		m.threads, err = fetchThreads(m.proc, m.process)
+		if err != nil {
+			m.logger.WithError(err).Error("couldn't read thread stats")
+		}
+	}
+	return nil
+}
+
+// tick takes another sample and measures it against the previous
+// one, given the whole system at about the same time.
+func (m *Monitor) tick(sys system) (Measure, error) {
+	newtotal, newcores := sys.total, sys.cores
+	newtarget, err := m.fetchProcesses(sys)
+	if err != nil {
+		return Measure{}, m.stopped(err, "process stats")
+	}
+
+	m.logger.WithFields(log.Fields{
+		"new total":  newtotal,
+		"new target": newtarget,
+		"old total":  m.total,
+		"old target": m.stats,
+	}).Debug("tick")
+	usage := usageDelta(m.stats.usage, newtarget.usage)
+	cpu := newtotal.sub(m.total)
+	measure := Measure{
+		PID:         m.process,
+		User:        usage.user,
+		System:      usage.system,
+		UserTotal:   cpu.User + cpu.Nice,
+		SystemTotal: cpu.System,
+		IdleTotal:   cpu.Idle,
+		CPU:         cpu,
+		Cores:       coreDelta(m.cores, newcores),
+		Processor:   newtarget.processor,
+		Memory:      newtarget.memory.RSS / 1024,
+		MemoryStats: newtarget.memory,
+		Processes:   len(newtarget.usage),
+		IO:          ioDelta(m.stats.io, newtarget.io),
+		Quota:       quotaDelta(m.stats.quota, newtarget.quota),
+		Pressure:    pressureDelta(m.stats.pressure, newtarget.pressure),
+		Host:        hostDelta(m.stats.host, newtarget.host),
+		Elapsed:     newtarget.at.Sub(m.stats.at),
+	}
+	if m.split {
+		// threads come and go all the time, so failing to
+		// read them isn't worth stopping over.  Without the last
+		// sample to measure against, this one just starts again.
+		newthreads, err := fetchThreads(m.proc, m.process)
+		if err != nil {
+			m.logger.WithError(err).Warn("couldn't read thread stats")
+		} else if m.threads != nil {
+			measure.Threads = threadDelta(m.threads, newthreads)
+		}
+		m.threads = newthreads
+	}
+	m.stats = newtarget
+	m.total = newtotal
+	m.cores = newcores
+	m.noteTasks()
+	return measure, nil
+}
+
+// Stop halts the background monitoring task and waits for it to
+// finish, after which Output is closed.  It is safe to call more than
+// once, and after monitoring has ended by itself.
 func (m *Monitor) Stop() {
-	m.done <- true
-	m.ticker.Stop()
+	m.cancel()
+	<-m.done
+}
+
+// Wait blocks until monitoring ends and returns the reason, as Err
+// does.
+func (m *Monitor) Wait() error {
+	<-m.done
+	return m.err
+}
+
+// Exit says how the process went away once Err returns
+// ErrProcessExited, and is nil until then.  Unless Options.Kernel was
+// set, the cause is always Exited.
+func (m *Monitor) Exit() *Exit {
+	select {
+	case <-m.finished:
+		return m.exit
+	default:
+		return nil
+	}
+}
+
+// Err returns nil while monitoring is running.  Once it has ended, it
+// returns context.Canceled if Stop was called, the context's error if
+// it was done, ErrProcessExited or ErrProcessReplaced if the process
+// went away, a *ParseError if something in /proc made no sense, or
+// whatever error prevented reading /proc, such as a permission error.
+func (m *Monitor) Err() error {
+	select {
+	case <-m.finished:
+		return m.err
+	default:
+		return nil
+	}
 }
@@ -4,10 +4,32 @@ package procmon
 
 import (
 	"github.com/stretchr/testify/assert"
+	"io"
 	"strings"
 	"testing"
 )
 
+// parseProcStat reads just the CPU time of a process, as the parser
+// these tests were written for did.
+func parseProcStat(in io.Reader) (point, error) {
+	stat, err := ParseProcStat(in)
+	if err != nil {
+		return point{}, err
+	}
+	return stat.point(), nil
+}
+
+// parseGlobalStat reads just the user and system time of the whole
+// system, counting nice time as user time, as the parser these tests
+// were written for did.
+func parseGlobalStat(in io.Reader) (point, error) {
+	total, _, err := parseCPUStats(in)
+	if err != nil {
+		return point{}, err
+	}
+	return point{user: total.User + total.Nice, system: total.System}, nil
+}
+
 func TestSimpleProcess(t *testing.T) {
 	point, err := parseProcStat(strings.NewReader(`1735 (sh) S 1734 1735 1735 34816 2679 4218880 655 3141 0 0 0 0 0 1 20 0 1 0 182865 12144640 534 18446744073709551615 4194304 4729572 140730058798800 140730058796792 139881841869352 0 0 2637828 2 0 0 0 17 0 0 0 0 0 0 6826728 6830659 16642048 140730058800890 140730058800894 140730058800894 140730058801136 0`))
 	if assert.NoError(t, err) {
@@ -35,23 +57,83 @@ func TestBrokenProcess(t *testing.T) {
 }
 
 func TestSimpleMemory(t *testing.T) {
-	point, err := parseMemStat(strings.NewReader(`2965 534 485 131 0 129 0`))
+	point, err := parseMemStat(strings.NewReader(`2965 534 485 131 0 129 0`), 4096)
 	if assert.NoError(t, err) {
-		assert.Equal(t, point, uint64(534))
+		assert.Equal(t, MemoryStats{
+			VSZ:    2965 * 4096,
+			RSS:    534 * 4096,
+			Shared: 485 * 4096,
+			Text:   131 * 4096,
+			Data:   129 * 4096,
+		}, point)
 	}
 }
 
 func TestBrokenMemory(t *testing.T) {
-	_, err := parseMemStat(strings.NewReader(`1735`))
+	_, err := parseMemStat(strings.NewReader(`1735`), 4096)
+	assert.Error(t, err)
+	_, err = parseMemStat(strings.NewReader(``), 4096)
 	assert.Error(t, err)
-	_, err = parseMemStat(strings.NewReader(``))
+	_, err = parseMemStat(strings.NewReader(`1735 (sh) S`), 4096)
 	assert.Error(t, err)
-	_, err = parseMemStat(strings.NewReader(`1735 (sh) S`))
+	_, err = parseMemStat(strings.NewReader(`1735 -24`), 4096)
 	assert.Error(t, err)
-	_, err = parseMemStat(strings.NewReader(`1735 -24`))
+}
+
+func TestBrokenMemoryFields(t *testing.T) {
+	// every field is needed now, not just RSS
+	_, err := parseMemStat(strings.NewReader(`1735 (sh) S 0 0 0`), 4096)
+	assert.Error(t, err)
+	_, err = parseMemStat(strings.NewReader(`1735 -24 0 0 0 0 0`), 4096)
+	assert.Error(t, err)
+	_, err = parseMemStat(strings.NewReader(`2968 534 446`), 4096)
+	assert.Error(t, err)
+}
+
+func TestMemStatus(t *testing.T) {
+	var stats MemoryStats
+	err := parseMemStatus(strings.NewReader(`Name:	sh
+Umask:	0022
+State:	S (sleeping)
+Tgid:	1735
+VmPeak:	   11860 kB
+VmSize:	   11860 kB
+VmLck:	       0 kB
+VmHWM:	    2136 kB
+VmRSS:	    2136 kB
+Threads:	1
+`), &stats)
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint64(11860*1024), stats.Peak)
+		assert.Equal(t, uint64(2136*1024), stats.HWM)
+	}
+	err = parseMemStatus(strings.NewReader("VmHWM:\tlots kB\n"), &stats)
 	assert.Error(t, err)
 }
 
+func TestSmapsRollup(t *testing.T) {
+	var stats MemoryStats
+	err := parseSmapsRollup(strings.NewReader(`55d4c2a1e000-7ffd5b1ff000 ---p 00000000 00:00 0                          [rollup]
+Rss:                2136 kB
+Pss:                 612 kB
+Pss_Anon:            188 kB
+Shared_Clean:       1524 kB
+Shared_Dirty:          0 kB
+Private_Clean:       312 kB
+Private_Dirty:       300 kB
+Referenced:         2136 kB
+Anonymous:           188 kB
+Swap:                 16 kB
+SwapPss:              16 kB
+Locked:                0 kB
+`), &stats)
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint64(612*1024), stats.PSS)
+		assert.Equal(t, uint64(612*1024), stats.USS)
+		assert.Equal(t, uint64(16*1024), stats.Swap)
+	}
+}
+
 func TestSimpleEverything(t *testing.T) {
 	input := `cpu  2019 0 929 687424 84 1 34 0 0 0
 cpu0 2019 0 929 687424 84 1 34 0 0 0
@@ -106,3 +188,95 @@ softirq 134624 0 68894 2777 14447 5489 0 18 0 29 42970`))
 	_, err = parseGlobalStat(strings.NewReader(`cpu      foo 4 21`))
 	assert.Error(t, err)
 }
+
+func TestChildren(t *testing.T) {
+	children, err := parseChildren(strings.NewReader(`1736 1740 2011 `))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []int{1736, 1740, 2011}, children)
+	}
+	children, err = parseChildren(strings.NewReader(``))
+	if assert.NoError(t, err) {
+		assert.Empty(t, children)
+	}
+	_, err = parseChildren(strings.NewReader(`1736 frob`))
+	assert.Error(t, err)
+}
+
+func TestThreadStat(t *testing.T) {
+	thread, err := parseThreadStat(strings.NewReader(`1741 (V8 Worker) S 1734 1735 1735 34816 2679 4218880 655 3141 0 0 152 189 162 199 20 0 1 0 182865 12144640 534 18446744073709551615 4194304 4729572 140730058798800 140730058796792 139881841869352 0 0 2637828 2 0 0 0 17 0 0 0 0 0 0 6826728 6830659 16642048 140730058800890 140730058800894 140730058800894 140730058801136 0`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "V8 Worker", thread.comm)
+		assert.Equal(t, uint64(152), thread.usage.user)
+		assert.Equal(t, uint64(189), thread.usage.system)
+	}
+	thread, err = parseThreadStat(strings.NewReader(`1741 (node) S 1734 1735 1735 34816 2679 4218880 655 3141 0 0 152 189 162 199 20 0 1 0 182865 12144640 534 18446744073709551615 4194304 4729572 140730058798800 140730058796792 139881841869352 0 0 2637828 2 0 0 0 17 0 0 0 0 0 0 6826728 6830659 16642048 140730058800890 140730058800894 140730058800894 140730058801136 0`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "node", thread.comm)
+		assert.Equal(t, uint64(152), thread.usage.user)
+		assert.Equal(t, uint64(189), thread.usage.system)
+	}
+	_, err = parseThreadStat(strings.NewReader(`1741 node S 1734`))
+	assert.Error(t, err)
+}
+
+func TestProcIO(t *testing.T) {
+	stats, err := parseProcIO(strings.NewReader(`rchar: 323934931
+wchar: 323929600
+syscr: 632687
+syscw: 632675
+read_bytes: 0
+write_bytes: 323932160
+cancelled_write_bytes: 4096
+`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, IOStats{323934931, 323929600, 632687, 632675, 0, 323932160, 4096}, stats)
+	}
+}
+
+func TestBrokenProcIO(t *testing.T) {
+	_, err := parseProcIO(strings.NewReader(``))
+	assert.Error(t, err)
+	_, err = parseProcIO(strings.NewReader(`rchar: 323934931
+wchar: 323929600
+`))
+	assert.Error(t, err)
+	_, err = parseProcIO(strings.NewReader(`rchar: 323934931
+wchar: 323929600
+syscr: lots
+syscw: 632675
+read_bytes: 0
+write_bytes: 323932160
+cancelled_write_bytes: 4096
+`))
+	assert.Error(t, err)
+}
+
+func TestEverythingBreakdown(t *testing.T) {
+	point, _, err := parseCPUStats(strings.NewReader(`cpu  2019 7 929 687424 84 1 34 12 3 2
+cpu0 2019 7 929 687424 84 1 34 12 3 2`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, CPUTimes{2019, 7, 929, 687424, 84, 1, 34, 12, 3, 2}, point)
+	}
+	// 2.6.11 has no guest fields
+	point, _, err = parseCPUStats(strings.NewReader(`cpu  2019 7 929 687424 84 1 34 12`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, CPUTimes{2019, 7, 929, 687424, 84, 1, 34, 12, 0, 0}, point)
+	}
+}
+
+func TestEverythingPerCore(t *testing.T) {
+	_, cores, err := parseCPUStats(strings.NewReader(`cpu  4038 0 1858 1374848 168 2 68 0 0 0
+cpu0 2019 0 929 687424 84 1 34 0 0 0
+cpu3 2019 0 929 687424 84 1 34 0 0 0
+intr 166310 14 10 0 0 0 0 0 0 1 0 0 0 156 0 0 0 7407 0 0
+ctxt 296117`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[int]CPUTimes{
+			0: {2019, 0, 929, 687424, 84, 1, 34, 0, 0, 0},
+			3: {2019, 0, 929, 687424, 84, 1, 34, 0, 0, 0},
+		}, cores)
+	}
+	_, _, err = parseCPUStats(strings.NewReader(`cpu  4038 0 1858 1374848 168 2 68 0 0 0
+cpuX 2019 0 929 687424 84 1 34 0 0 0`))
+	assert.Error(t, err)
+}
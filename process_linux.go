@@ -4,9 +4,11 @@ package procmon
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"strconv"
@@ -14,136 +16,418 @@ import (
 )
 
 func (m *Monitor) preflight() error {
-	_, err := m.fetchProcessUsage()
+	stat, err := fetchProcessStat(m.proc, m.process)
 	if err != nil {
 		return err
 	}
-	_, err = m.fetchTotalUsage()
+	m.start = stat.StartTime
+	_, _, err = fetchCPUUsage(m.proc)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func parseProcStat(in io.Reader) (point, error) {
-	// per proc(5) we are after fields utime and stime, numbers 14
-	// and 15.
+func parseThreadStat(in io.Reader) (thread, error) {
+	// the same format as the process stat file.
+	stat, err := ParseProcStat(in)
+	if err != nil {
+		return thread{}, err
+	}
+	return thread{stat.Comm, stat.point()}, nil
+}
+
+func parseMemStat(in io.Reader, pageSize uint64) (MemoryStats, error) {
+	// per proc(5) the fields are size, resident, shared, text, lib,
+	// data and dt, all measured in pages.  lib and dt are always 0.
 	contents, err := ioutil.ReadAll(in)
 	if err != nil {
-		return point{}, err
+		return MemoryStats{}, err
 	}
-	fields := strings.Split(string(contents), " ")
-	if len(fields) < 15 {
-		return point{}, fmt.Errorf("Not enough fields")
+	fields := strings.Fields(string(contents))
+	if len(fields) < 6 {
+		return MemoryStats{}, fmt.Errorf("Not enough fields")
 	}
-	cutime, err := strconv.ParseUint(fields[13], 10, 64)
-	if err != nil {
-		return point{}, err
+	var pages [6]uint64
+	for i := range pages {
+		pages[i], err = strconv.ParseUint(fields[i], 10, 64)
+		if err != nil {
+			return MemoryStats{}, err
+		}
 	}
-	cstime, err := strconv.ParseUint(fields[14], 10, 64)
-	if err != nil {
-		return point{}, err
+	return MemoryStats{
+		VSZ:    pages[0] * pageSize,
+		RSS:    pages[1] * pageSize,
+		Shared: pages[2] * pageSize,
+		Text:   pages[3] * pageSize,
+		Data:   pages[5] * pageSize,
+	}, nil
+}
+
+// parseKB reads "Key:   value kB" lines, as found in
+// /proc/<pid>/status and /proc/<pid>/smaps_rollup, storing the value
+// in bytes for each key present in keys.  Other lines are ignored.
+func parseKB(in io.Reader, keys map[string]*uint64) error {
+	s := bufio.NewScanner(in)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		target, ok := keys[strings.TrimSuffix(fields[0], ":")]
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return err
+		}
+		if len(fields) > 2 && fields[2] == "kB" {
+			value *= 1024
+		}
+		*target = value
 	}
-	return point{cutime, cstime, 0}, nil
+	return s.Err()
 }
 
-func parseMemStat(in io.Reader) (uint64, error) {
-	// per proc(5) we are after field rss, number 2.
-	contents, err := ioutil.ReadAll(in)
-	if err != nil {
-		return 0, err
+func parseMemStatus(in io.Reader, stats *MemoryStats) error {
+	return parseKB(in, map[string]*uint64{
+		"VmHWM":  &stats.HWM,
+		"VmPeak": &stats.Peak,
+	})
+}
+
+func parseSmapsRollup(in io.Reader, stats *MemoryStats) error {
+	var clean, dirty uint64
+	err := parseKB(in, map[string]*uint64{
+		"Pss":           &stats.PSS,
+		"Private_Clean": &clean,
+		"Private_Dirty": &dirty,
+		"Swap":          &stats.Swap,
+	})
+	stats.USS = clean + dirty
+	return err
+}
+
+func parseProcIO(in io.Reader) (IOStats, error) {
+	// per proc(5) this is "key: value" lines.  All of the fields are
+	// required, as a partial read would produce nonsense deltas.
+	var stats IOStats
+	keys := map[string]*uint64{
+		"rchar":                 &stats.RChar,
+		"wchar":                 &stats.WChar,
+		"syscr":                 &stats.SyscR,
+		"syscw":                 &stats.SyscW,
+		"read_bytes":            &stats.ReadBytes,
+		"write_bytes":           &stats.WriteBytes,
+		"cancelled_write_bytes": &stats.CancelledWriteBytes,
 	}
-	fields := strings.Split(string(contents), " ")
-	if len(fields) < 2 {
-		return 0, fmt.Errorf("Not enough fields")
+	seen := 0
+	s := bufio.NewScanner(in)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 {
+			return IOStats{}, fmt.Errorf("Malformed io line %q", s.Text())
+		}
+		target, ok := keys[strings.TrimSuffix(fields[0], ":")]
+		if !ok {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return IOStats{}, err
+		}
+		*target = value
+		seen++
 	}
-	rss, err := strconv.ParseUint(fields[1], 10, 64)
-	if err != nil {
-		return 0, err
+	if err := s.Err(); err != nil {
+		return IOStats{}, err
+	}
+	if seen < len(keys) {
+		return IOStats{}, fmt.Errorf("Only %d of %d io fields seen", seen, len(keys))
 	}
-	return rss, nil
+	return stats, nil
 }
 
-func parseGlobalStat(in io.Reader) (point, error) {
-	// again per proc(5) we are after the user and system fields from
-	// the cpu line, which are fields 1 and 3.  This is complicated
-	// because /proc/stat gives lots of information we don't want or
-	// need.
+// parseCPULine reads one of the cpu lines of /proc/stat.  Per proc(5)
+// these are user, nice, system, idle, then iowait, irq, softirq,
+// steal, guest and guest_nice as successive kernels added them.
+func parseCPULine(line string) (string, CPUTimes, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "cpu") {
+		return "", CPUTimes{}, fmt.Errorf("Weird cpu line doesn't start with cpu?!: %q", line)
+	}
+	if len(fields) < 5 {
+		return "", CPUTimes{}, fmt.Errorf("cpu line ended before idle data seen: %q", line)
+	}
+	var times CPUTimes
+	targets := []*uint64{
+		&times.User, &times.Nice, &times.System, &times.Idle,
+		&times.IOWait, &times.IRQ, &times.SoftIRQ, &times.Steal,
+		&times.Guest, &times.GuestNice,
+	}
+	for i, field := range fields[1:] {
+		if i == len(targets) {
+			break
+		}
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return "", CPUTimes{}, err
+		}
+		*targets[i] = value
+	}
+	return fields[0], times, nil
+}
+
+func parseCPUStats(in io.Reader) (CPUTimes, map[int]CPUTimes, error) {
+	// again per proc(5) we are after the aggregate cpu line and the
+	// cpuN line for each online CPU.  This is complicated because
+	// /proc/stat gives lots of information we don't want or need.
+	var total *CPUTimes
+	cores := make(map[int]CPUTimes)
 	s := bufio.NewScanner(in)
 	for s.Scan() {
-		if strings.HasPrefix(s.Text(), "cpu ") {
-			fs := bufio.NewScanner(strings.NewReader(s.Text()))
-			fs.Split(bufio.ScanWords)
-			if !fs.Scan() {
-				return point{}, fmt.Errorf("cpu line ended before user data seen: %q", s.Text())
-			}
-			if fs.Text() != "cpu" {
-				return point{}, fmt.Errorf("Weird cpu line doesn't start with cpu?!: %q", s.Text())
-			}
-			if !fs.Scan() {
-				return point{}, fmt.Errorf("cpu line ended before user data seen: %q", s.Text())
-			}
-			utime, err := strconv.ParseUint(fs.Text(), 10, 64)
-			if err != nil {
-				return point{}, err
-			}
-			if !fs.Scan() {
-				return point{}, fmt.Errorf("cpu line ended before user niced data seen: %q", s.Text())
-			}
-			unicetime, err := strconv.ParseUint(fs.Text(), 10, 64)
-			if err != nil {
-				return point{}, err
-			}
-			utime += unicetime
-			if !fs.Scan() {
-				return point{}, fmt.Errorf("cpu line ended before system data seen: %q", s.Text())
-			}
-			stime, err := strconv.ParseUint(fs.Text(), 10, 64)
-			if err != nil {
-				return point{}, err
-			}
-			if !fs.Scan() {
-				return point{}, fmt.Errorf("cpu line ended before idle data seen: %q", s.Text())
-			}
-			itime, err := strconv.ParseUint(fs.Text(), 10, 64)
-			if err != nil {
-				return point{}, err
-			}
+		if !strings.HasPrefix(s.Text(), "cpu") {
+			continue
+		}
+		name, times, err := parseCPULine(s.Text())
+		if err != nil {
+			return CPUTimes{}, nil, err
+		}
+		if name == "cpu" {
 			log.WithFields(log.Fields{
-				"raw":  s.Text(),
-				"user": utime,
-				"sys":  stime,
-				"idle": itime,
+				"raw":   s.Text(),
+				"times": times,
 			}).Debug("reading /proc/stat")
-			return point{utime, stime, itime}, nil
+			total = &times
+			continue
+		}
+		core, err := strconv.Atoi(strings.TrimPrefix(name, "cpu"))
+		if err != nil {
+			return CPUTimes{}, nil, fmt.Errorf("Weird cpu line for %q", name)
+		}
+		cores[core] = times
+	}
+	if err := s.Err(); err != nil {
+		return CPUTimes{}, nil, err
+	}
+	if total == nil {
+		return CPUTimes{}, nil, fmt.Errorf("No line starting with 'cpu' seen")
+	}
+	return *total, cores, nil
+}
+
+func parseChildren(in io.Reader) ([]int, error) {
+	// per proc(5) this is a space separated list of child pids,
+	// possibly empty.
+	contents, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	var children []int
+	for _, field := range strings.Fields(string(contents)) {
+		child, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, err
 		}
+		children = append(children, child)
+	}
+	return children, nil
+}
+
+func fetchProcessStat(proc fs.FS, pid int) (*ProcStat, error) {
+	path := fmt.Sprintf("%d/stat", pid)
+	file, err := proc.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	stat, err := ParseProcStat(file)
+	if err != nil {
+		return nil, &ParseError{path, err}
 	}
-	return point{}, fmt.Errorf("No line starting with 'cpu' seen")
+	return stat, nil
 }
 
-func (m *Monitor) fetchProcessUsage() (point, error) {
-	file, err := os.Open(fmt.Sprintf("/proc/%d/stat", m.process))
+func fetchProcessMemory(proc fs.FS, pid int, detailed bool) (MemoryStats, error) {
+	path := fmt.Sprintf("%d/statm", pid)
+	file, err := proc.Open(path)
 	if err != nil {
-		return point{}, err
+		return MemoryStats{}, err
 	}
 	defer file.Close()
-	return parseProcStat(file)
+	stats, err := parseMemStat(file, uint64(os.Getpagesize()))
+	if err != nil {
+		return MemoryStats{}, &ParseError{path, err}
+	}
+	path = fmt.Sprintf("%d/status", pid)
+	status, err := proc.Open(path)
+	if err != nil {
+		return MemoryStats{}, err
+	}
+	defer status.Close()
+	if err := parseMemStatus(status, &stats); err != nil {
+		return MemoryStats{}, &ParseError{path, err}
+	}
+	if detailed {
+		// smaps_rollup needs ptrace access and a 4.14 kernel, so
+		// go without rather than failing.
+		path = fmt.Sprintf("%d/smaps_rollup", pid)
+		rollup, err := proc.Open(path)
+		if err != nil {
+			log.WithField("process", pid).WithError(err).Debug("couldn't read smaps_rollup")
+			return stats, nil
+		}
+		defer rollup.Close()
+		if err := parseSmapsRollup(rollup, &stats); err != nil {
+			return MemoryStats{}, &ParseError{path, err}
+		}
+	}
+	return stats, nil
 }
 
-func (m *Monitor) fetchProcessMemory() (uint64, error) {
-	file, err := os.Open(fmt.Sprintf("/proc/%d/statm", m.process))
+func fetchProcessIO(proc fs.FS, pid int) (IOStats, error) {
+	file, err := proc.Open(fmt.Sprintf("%d/io", pid))
 	if err != nil {
-		return 0, err
+		return IOStats{}, err
 	}
 	defer file.Close()
-	return parseMemStat(file)
+	return parseProcIO(file)
+}
+
+func fetchThreads(proc fs.FS, pid int) (map[int]thread, error) {
+	tasks, err := fs.ReadDir(proc, fmt.Sprintf("%d/task", pid))
+	if err != nil {
+		return nil, err
+	}
+	threads := make(map[int]thread)
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		file, err := proc.Open(fmt.Sprintf("%d/task/%d/stat", pid, tid))
+		if err != nil {
+			// the thread exited after we listed it
+			continue
+		}
+		t, err := parseThreadStat(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		threads[tid] = t
+	}
+	return threads, nil
+}
+
+func fetchTasks(proc fs.FS, pid int) (map[int]bool, error) {
+	tasks, err := fs.ReadDir(proc, fmt.Sprintf("%d/task", pid))
+	if err != nil {
+		return nil, err
+	}
+	tids := make(map[int]bool)
+	for _, task := range tasks {
+		if tid, err := strconv.Atoi(task.Name()); err == nil {
+			tids[tid] = true
+		}
+	}
+	return tids, nil
+}
+
+func fetchChildren(proc fs.FS, pid int) ([]int, error) {
+	// every thread has its own list of children.
+	tasks, err := fs.ReadDir(proc, fmt.Sprintf("%d/task", pid))
+	if err != nil {
+		return nil, err
+	}
+	var children []int
+	for _, task := range tasks {
+		file, err := proc.Open(fmt.Sprintf("%d/task/%s/children", pid, task.Name()))
+		if err != nil {
+			// the thread exited after we listed it
+			continue
+		}
+		taskChildren, err := parseChildren(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, taskChildren...)
+	}
+	return children, nil
+}
+
+// scanChildren finds the children of every process by reading the
+// ppid of everything in proc.  This is the fallback for kernels built
+// without CONFIG_PROC_CHILDREN.  Processes which exit while being
+// read, or can't be made sense of, are left out, as any of them may
+// be unrelated to the caller.
+func scanChildren(proc fs.FS) (map[int][]int, error) {
+	entries, err := fs.ReadDir(proc, ".")
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[int][]int)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		file, err := proc.Open(fmt.Sprintf("%d/stat", pid))
+		if err != nil {
+			continue
+		}
+		stat, err := ParseProcStat(file)
+		file.Close()
+		if err != nil {
+			continue
+		}
+		children[stat.PPID] = append(children[stat.PPID], pid)
+	}
+	return children, nil
+}
+
+func fetchDescendants(proc fs.FS, pid int) ([]int, error) {
+	children := func(pid int) ([]int, error) { return fetchChildren(proc, pid) }
+	if _, err := fs.Stat(proc, fmt.Sprintf("%d/task/%d/children", pid, pid)); errors.Is(err, fs.ErrNotExist) {
+		table, err := scanChildren(proc)
+		if err != nil {
+			return nil, err
+		}
+		children = func(pid int) ([]int, error) { return table[pid], nil }
+	}
+	var descendants []int
+	seen := map[int]bool{pid: true}
+	queue := []int{pid}
+	for len(queue) > 0 {
+		found, err := children(queue[0])
+		queue = queue[1:]
+		if err != nil {
+			// it exited after we found it, taking its children
+			// with it or reparenting them elsewhere.
+			continue
+		}
+		for _, child := range found {
+			if !seen[child] {
+				seen[child] = true
+				descendants = append(descendants, child)
+				queue = append(queue, child)
+			}
+		}
+	}
+	return descendants, nil
 }
 
-func (m *Monitor) fetchTotalUsage() (point, error) {
-	file, err := os.Open("/proc/stat")
+func fetchCPUUsage(proc fs.FS) (CPUTimes, map[int]CPUTimes, error) {
+	file, err := proc.Open("stat")
 	if err != nil {
-		return point{}, err
+		return CPUTimes{}, nil, err
 	}
 	defer file.Close()
-	return parseGlobalStat(file)
+	total, cores, err := parseCPUStats(file)
+	if err != nil {
+		return CPUTimes{}, nil, &ParseError{"stat", err}
+	}
+	return total, cores, nil
 }
@@ -2,7 +2,10 @@
 
 package procmon
 
-import "errors"
+import (
+	"errors"
+	"io/fs"
+)
 
 var errNotSupported = errors.New("Not supported on this OS")
 
@@ -10,14 +13,30 @@ func (m *Monitor) preflight() error {
 	return errNotSupported
 }
 
-func (m *Monitor) fetchProcessUsage() (point, error) {
-	return point{}, errNotSupported
+func fetchProcessStat(proc fs.FS, pid int) (*ProcStat, error) {
+	return nil, errNotSupported
 }
 
-func (m *Monitor) fetchProcessMemory() (uint64, error) {
-	return 0, errNotSupported
+func fetchProcessMemory(proc fs.FS, pid int, detailed bool) (MemoryStats, error) {
+	return MemoryStats{}, errNotSupported
 }
 
-func (m *Monitor) fetchTotalUsage() (point, error) {
-	return point{}, errNotSupported
+func fetchProcessIO(proc fs.FS, pid int) (IOStats, error) {
+	return IOStats{}, errNotSupported
+}
+
+func fetchThreads(proc fs.FS, pid int) (map[int]thread, error) {
+	return nil, errNotSupported
+}
+
+func fetchTasks(proc fs.FS, pid int) (map[int]bool, error) {
+	return nil, errNotSupported
+}
+
+func fetchDescendants(proc fs.FS, pid int) ([]int, error) {
+	return nil, errNotSupported
+}
+
+func fetchCPUUsage(proc fs.FS) (CPUTimes, map[int]CPUTimes, error) {
+	return CPUTimes{}, nil, errNotSupported
 }
@@ -0,0 +1,151 @@
+// +build linux
+
+package procmon
+
+import (
+	"context"
+	"github.com/meteor/procmon/clock"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestNegativeInterval(t *testing.T) {
+	_, err := NewWithOptions(make(chan Measure), os.Getpid(), Options{Interval: -time.Second})
+	assert.Error(t, err)
+}
+
+func TestSetInterval(t *testing.T) {
+	out := make(chan Measure, 1)
+	m, err := NewWithOptions(out, os.Getpid(), Options{Interval: time.Hour, Policy: BlockWhenFull})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer m.Stop()
+	assert.Error(t, m.SetInterval(0))
+	assert.NoError(t, m.SetInterval(10*time.Millisecond))
+	select {
+	case <-out:
+	case <-time.After(5 * time.Second):
+		t.Error("no measure after shortening the interval")
+	}
+}
+
+func TestTree(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skip("can't start child:", err)
+	}
+	defer cmd.Process.Kill()
+	m := &Monitor{proc: defaultProc, clock: clock.Real, process: os.Getpid(), tree: true, logger: log.NewEntry(log.StandardLogger())}
+	if !assert.NoError(t, m.preflight()) {
+		return
+	}
+	sample, err := m.fetchProcesses(system{})
+	if assert.NoError(t, err) {
+		assert.Contains(t, sample.usage, cmd.Process.Pid)
+		assert.Contains(t, sample.io, os.Getpid())
+		assert.NotZero(t, sample.memory.RSS)
+	}
+	table, err := scanChildren(defaultProc)
+	if assert.NoError(t, err) {
+		assert.Contains(t, table[os.Getpid()], cmd.Process.Pid)
+	}
+}
+
+func TestSystemPressure(t *testing.T) {
+	if _, err := os.Stat("/proc/pressure"); err != nil {
+		t.Skip("kernel has no PSI:", err)
+	}
+	m := &Monitor{proc: defaultProc, clock: clock.Real, process: os.Getpid(), psi: HostPressure, logger: log.NewEntry(log.StandardLogger())}
+	if !assert.NoError(t, m.preflight()) {
+		return
+	}
+	sys, err := m.fetchSystem()
+	if !assert.NoError(t, err) {
+		return
+	}
+	sample, err := m.fetchProcesses(sys)
+	if assert.NoError(t, err) && assert.NotNil(t, sample.pressure) {
+		assert.NotZero(t, sample.pressure.CPU.Some.Total)
+	}
+}
+
+func TestStop(t *testing.T) {
+	out := make(chan Measure)
+	m, err := NewWithOptions(out, os.Getpid(), Options{Interval: time.Millisecond, Policy: BlockWhenFull})
+	if !assert.NoError(t, err) {
+		return
+	}
+	<-out
+	assert.NoError(t, m.Err())
+	m.Stop()
+	m.Stop()
+	_, ok := <-out
+	assert.False(t, ok)
+	assert.Equal(t, context.Canceled, m.Wait())
+	assert.Equal(t, context.Canceled, m.Err())
+	assert.Equal(t, context.Canceled, m.SetInterval(time.Second))
+}
+
+func TestContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	out := make(chan Measure, 100)
+	m, err := NewWithContext(ctx, out, os.Getpid(), Options{Interval: time.Hour})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, context.DeadlineExceeded, m.Wait())
+	_, ok := <-out
+	assert.False(t, ok)
+}
+
+func TestProcessExited(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Skip("can't start child:", err)
+	}
+	out := make(chan Measure, 100)
+	m, err := NewWithOptions(out, cmd.Process.Pid, Options{Interval: time.Millisecond})
+	if !assert.NoError(t, err) {
+		return
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+	assert.Equal(t, ErrProcessExited, m.Wait())
+	for range out {
+	}
+	m.Stop()
+}
+
+func TestProcessReplaced(t *testing.T) {
+	m := &Monitor{proc: defaultProc, clock: clock.Real, process: os.Getpid(), logger: log.NewEntry(log.StandardLogger())}
+	if !assert.NoError(t, m.preflight()) {
+		return
+	}
+	_, err := m.fetchProcesses(system{})
+	assert.NoError(t, err)
+	m.start--
+	_, err = m.fetchProcesses(system{})
+	assert.Equal(t, ErrProcessReplaced, err)
+}
+
+func TestSnapshot(t *testing.T) {
+	table, err := Snapshot(nil, 10*time.Millisecond)
+	if !assert.NoError(t, err) {
+		return
+	}
+	for _, row := range table {
+		if row.PID == os.Getpid() {
+			assert.NotZero(t, row.RSS)
+			assert.NotZero(t, row.Threads)
+			assert.NotEmpty(t, row.User)
+			return
+		}
+	}
+	t.Error("didn't find ourselves in the process table")
+}
@@ -0,0 +1,87 @@
+package procmon
+
+import (
+	"github.com/meteor/procmon/cgroup"
+	"math"
+)
+
+// Quota is the CPU bandwidth limit of the monitored process's cgroup,
+// and how the whole group fared against it during the interval.  A
+// container's processes share its quota, so the group is what gets
+// throttled.
+type Quota struct {
+	// CPUs is the limit, so 0.5 for half a CPU, or +Inf if the group
+	// is unlimited.
+	CPUs float64
+	// UsageUsec is the CPU time used by the whole group.
+	UsageUsec uint64
+	// Periods is the number of enforcement periods in which the group
+	// was runnable.
+	Periods uint64
+	// Throttled is the number of those periods in which the group used
+	// up its quota and had to wait for the next.
+	Throttled uint64
+	// ThrottledUsec is the time the group spent throttled.
+	ThrottledUsec uint64
+}
+
+// What is read from the monitored process's cgroup at one point in
+// time
+type quotaSample struct {
+	stats cgroup.CPUStats
+	max   cgroup.CPUMax
+}
+
+// fetchQuota reads the monitored process's cgroup, if asked to.  A
+// failure isn't worth stopping over, as the group may be removed from
+// under a process which is about to move or exit anyway.
+func (m *Monitor) fetchQuota() *quotaSample {
+	if m.group == nil {
+		return nil
+	}
+	stats, max, err := m.group.CPU()
+	if err != nil {
+		m.logger.WithError(err).Warn("couldn't read cgroup CPU stats")
+		return nil
+	}
+	return &quotaSample{stats, max}
+}
+
+// quotaDelta measures the group's CPU use between two samples, or
+// returns nil if either is missing.  The counters start again from
+// zero if the group is recreated between samples, so they're clamped
+// rather than left to wrap around.
+func quotaDelta(old, new *quotaSample) *Quota {
+	if old == nil || new == nil {
+		return nil
+	}
+	return &Quota{
+		CPUs:          new.max.CPUs(),
+		UsageUsec:     since(new.stats.UsageUsec, old.stats.UsageUsec),
+		Periods:       since(new.stats.NrPeriods, old.stats.NrPeriods),
+		Throttled:     since(new.stats.NrThrottled, old.stats.NrThrottled),
+		ThrottledUsec: since(new.stats.ThrottledUsec, old.stats.ThrottledUsec),
+	}
+}
+
+// QuotaPerc calculates the CPU time used by the monitored process's
+// cgroup as a percentage of its quota, so 100 means it used all it was
+// allowed.  It is 0 if the group is unlimited, and NaN if the quota
+// wasn't read.
+func (m *Measure) QuotaPerc() float64 {
+	if m.Quota == nil || m.Elapsed <= 0 {
+		return math.NaN()
+	}
+	allowed := m.Quota.CPUs * float64(m.Elapsed.Microseconds())
+	return 100.0 * float64(m.Quota.UsageUsec) / allowed
+}
+
+// ThrottledPerc calculates the percentage of enforcement periods in
+// which the monitored process's cgroup was throttled.  It is NaN if
+// the quota wasn't read or the group has none.
+func (m *Measure) ThrottledPerc() float64 {
+	if m.Quota == nil || m.Quota.Periods == 0 {
+		return math.NaN()
+	}
+	return 100.0 * float64(m.Quota.Throttled) / float64(m.Quota.Periods)
+}
@@ -0,0 +1,44 @@
+package procmon
+
+import (
+	"github.com/meteor/procmon/cgroup"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestQuotaDelta(t *testing.T) {
+	old := &quotaSample{cgroup.CPUStats{UsageUsec: 1000000, NrPeriods: 100, NrThrottled: 10, ThrottledUsec: 50000}, cgroup.CPUMax{Quota: 50000, Period: 100000}}
+	new := &quotaSample{cgroup.CPUStats{UsageUsec: 1500000, NrPeriods: 110, NrThrottled: 15, ThrottledUsec: 80000}, cgroup.CPUMax{Quota: 50000, Period: 100000}}
+	assert.Nil(t, quotaDelta(nil, new))
+	assert.Nil(t, quotaDelta(old, nil))
+	quota := quotaDelta(old, new)
+	assert.Equal(t, &Quota{0.5, 500000, 10, 5, 30000}, quota)
+
+	m := Measure{Quota: quota, Elapsed: 2 * time.Second}
+	assert.Equal(t, 50.0, m.QuotaPerc())
+	assert.Equal(t, 50.0, m.ThrottledPerc())
+}
+
+func TestQuotaDeltaBackwards(t *testing.T) {
+	// the group was removed and recreated between samples
+	old := &quotaSample{cgroup.CPUStats{UsageUsec: 1000000, NrPeriods: 100, NrThrottled: 10, ThrottledUsec: 50000}, cgroup.CPUMax{Quota: 50000, Period: 100000}}
+	new := &quotaSample{cgroup.CPUStats{UsageUsec: 2000, NrPeriods: 1, NrThrottled: 0, ThrottledUsec: 0}, cgroup.CPUMax{Quota: 50000, Period: 100000}}
+	quota := quotaDelta(old, new)
+	assert.Equal(t, &Quota{0.5, 0, 0, 0, 0}, quota)
+
+	m := Measure{Quota: quota, Elapsed: time.Second}
+	assert.Equal(t, 0.0, m.QuotaPerc())
+	assert.True(t, math.IsNaN(m.ThrottledPerc()))
+}
+
+func TestQuotaUnlimited(t *testing.T) {
+	m := Measure{Quota: &Quota{CPUs: math.Inf(1), UsageUsec: 500000}, Elapsed: time.Second}
+	assert.Equal(t, 0.0, m.QuotaPerc())
+	assert.True(t, math.IsNaN(m.ThrottledPerc()))
+
+	m = Measure{Elapsed: time.Second}
+	assert.True(t, math.IsNaN(m.QuotaPerc()))
+	assert.True(t, math.IsNaN(m.ThrottledPerc()))
+}
@@ -0,0 +1,100 @@
+package procmon
+
+import (
+	"io/fs"
+	"sort"
+	"time"
+)
+
+// ProcessInfo is one process's row in a Table.
+type ProcessInfo struct {
+	PID  int
+	PPID int
+	Comm string
+	// User is the name of the process's owner, or their uid if it
+	// has no name, or empty if the procfs read doesn't say.
+	User    string
+	Cmdline string
+	// CPUPerc is the percentage of all CPU time the process used
+	// between the two scans, as in Measure.UserPerc.
+	CPUPerc float64
+	// RSS is the resident set size in bytes
+	RSS     uint64
+	Threads int
+}
+
+// Table lists the processes running on the host.
+type Table []ProcessInfo
+
+// SortByCPU orders the table by CPU usage, highest first.
+func (t Table) SortByCPU() {
+	sort.SliceStable(t, func(i, j int) bool { return t[i].CPUPerc > t[j].CPUPerc })
+}
+
+// SortByMemory orders the table by resident set size, highest first.
+func (t Table) SortByMemory() {
+	sort.SliceStable(t, func(i, j int) bool { return t[i].RSS > t[j].RSS })
+}
+
+// Top returns up to the first n rows of the table, or none if n is
+// negative.
+func (t Table) Top(n int) Table {
+	if n < 0 {
+		n = 0
+	}
+	if n < len(t) {
+		return t[:n]
+	}
+	return t
+}
+
+// scanned is what a Scan knows about each process
+type scanned struct {
+	info  ProcessInfo
+	usage point
+}
+
+// Scan is the state of every process on the host at one point in
+// time.  Two scans are needed to say anything about CPU usage.
+type Scan struct {
+	At        time.Time
+	total     CPUTimes
+	processes map[int]scanned
+}
+
+// Since compares s with an earlier scan and returns the processes
+// present in s, ordered by PID.  Processes started since earlier count
+// all of their CPU time.
+func (s *Scan) Since(earlier *Scan) Table {
+	total := s.total.sub(earlier.total)
+	jiffies := float64(total.total())
+	table := make(Table, 0, len(s.processes))
+	for pid, now := range s.processes {
+		usage := usageDelta(
+			map[int]point{pid: earlier.processes[pid].usage},
+			map[int]point{pid: now.usage},
+		)
+		info := now.info
+		if jiffies > 0 {
+			info.CPUPerc = 100.0 * float64(usage.user+usage.system) / jiffies
+		}
+		table = append(table, info)
+	}
+	sort.Slice(table, func(i, j int) bool { return table[i].PID < table[j].PID })
+	return table
+}
+
+// Snapshot scans every process in proc, or /proc if it is nil, twice,
+// wait apart, and returns what they were doing in between.
+func Snapshot(proc fs.FS, wait time.Duration) (Table, error) {
+	earlier, err := NewScan(proc)
+	if err != nil {
+		return nil, err
+	}
+	time.Sleep(wait)
+	later, err := NewScan(proc)
+	if err != nil {
+		return nil, err
+	}
+	return later.Since(earlier), nil
+}
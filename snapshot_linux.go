@@ -0,0 +1,87 @@
+// +build linux
+
+package procmon
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// NewScan reads the state of every process in proc, or /proc if it is
+// nil.  Processes which exit while being read are left out.
+func NewScan(proc fs.FS) (*Scan, error) {
+	if proc == nil {
+		proc = defaultProc
+	}
+	total, _, err := fetchCPUUsage(proc)
+	if err != nil {
+		return nil, err
+	}
+	pids, err := allPids(proc)
+	if err != nil {
+		return nil, err
+	}
+	s := &Scan{time.Now(), total, make(map[int]scanned, len(pids))}
+	pageSize := uint64(os.Getpagesize())
+	users := make(map[uint32]string)
+	for _, pid := range pids {
+		stat, err := fetchProcessStat(proc, pid)
+		if err != nil {
+			continue
+		}
+		statm, err := proc.Open(fmt.Sprintf("%d/statm", pid))
+		if err != nil {
+			continue
+		}
+		memory, err := parseMemStat(statm, pageSize)
+		statm.Close()
+		if err != nil {
+			continue
+		}
+		cmdline, err := fs.ReadFile(proc, fmt.Sprintf("%d/cmdline", pid))
+		if err != nil {
+			continue
+		}
+		dir, err := fs.Stat(proc, strconv.Itoa(pid))
+		if err != nil {
+			continue
+		}
+		// the owner is only known if proc is a real directory
+		var owner string
+		if st, ok := dir.Sys().(*syscall.Stat_t); ok {
+			owner = username(users, st.Uid)
+		}
+		s.processes[pid] = scanned{
+			ProcessInfo{
+				PID:     pid,
+				PPID:    stat.PPID,
+				Comm:    stat.Comm,
+				User:    owner,
+				Cmdline: parseCmdline(cmdline),
+				RSS:     memory.RSS,
+				Threads: int(stat.NumThreads),
+			},
+			stat.point(),
+		}
+	}
+	return s, nil
+}
+
+// username looks up uid, remembering the answer in cache as the same
+// few users own most processes.
+func username(cache map[uint32]string, uid uint32) string {
+	if name, ok := cache[uid]; ok {
+		return name
+	}
+	name := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	cache[uid] = name
+	return name
+}
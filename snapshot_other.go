@@ -0,0 +1,11 @@
+// +build !linux
+
+package procmon
+
+import "io/fs"
+
+// NewScan reads the state of every process in proc.  On this
+// operating system it is not supported.
+func NewScan(proc fs.FS) (*Scan, error) {
+	return nil, errNotSupported
+}
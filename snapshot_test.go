@@ -0,0 +1,54 @@
+package procmon
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestScanSince(t *testing.T) {
+	earlier := &Scan{
+		total: CPUTimes{User: 1000, Idle: 1000},
+		processes: map[int]scanned{
+			1: {ProcessInfo{PID: 1, Comm: "init"}, point{10, 10, 1}},
+			2: {ProcessInfo{PID: 2, Comm: "gone"}, point{10, 10, 2}},
+			4: {ProcessInfo{PID: 4, Comm: "old"}, point{500, 0, 3}},
+		},
+	}
+	later := &Scan{
+		total: CPUTimes{User: 1150, Idle: 1050},
+		processes: map[int]scanned{
+			1: {ProcessInfo{PID: 1, Comm: "init"}, point{12, 12, 1}},
+			3: {ProcessInfo{PID: 3, Comm: "new"}, point{20, 0, 5}},
+			4: {ProcessInfo{PID: 4, Comm: "reused"}, point{30, 10, 6}},
+		},
+	}
+	assert.Equal(t, Table{
+		{PID: 1, Comm: "init", CPUPerc: 2},
+		{PID: 3, Comm: "new", CPUPerc: 10},
+		{PID: 4, Comm: "reused", CPUPerc: 20},
+	}, later.Since(earlier))
+}
+
+func TestTableOrder(t *testing.T) {
+	table := Table{
+		{PID: 1, CPUPerc: 5, RSS: 300},
+		{PID: 2, CPUPerc: 50, RSS: 100},
+		{PID: 3, CPUPerc: 5, RSS: 200},
+	}
+	table.SortByCPU()
+	assert.Equal(t, []int{2, 1, 3}, pids(table))
+	table.SortByMemory()
+	assert.Equal(t, []int{1, 3, 2}, pids(table))
+	assert.Equal(t, []int{1, 3}, pids(table.Top(2)))
+	assert.Equal(t, []int{1, 3, 2}, pids(table.Top(10)))
+	assert.Empty(t, table.Top(0))
+	assert.Empty(t, table.Top(-1))
+}
+
+func pids(table Table) []int {
+	var result []int
+	for _, row := range table {
+		result = append(result, row.PID)
+	}
+	return result
+}
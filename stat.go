@@ -0,0 +1,203 @@
+package procmon
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// ProcStat is the contents of /proc/<pid>/stat, as documented in
+// proc(5).  Times are in clock ticks and addresses are raw.  Fields
+// added after Linux 2.6.24 are zero on kernels which lack them.
+type ProcStat struct {
+	PID                 int
+	Comm                string
+	State               byte
+	PPID                int
+	PGrp                int
+	Session             int
+	TTYNr               int
+	TPGid               int
+	Flags               uint64
+	MinFlt              uint64
+	CMinFlt             uint64
+	MajFlt              uint64
+	CMajFlt             uint64
+	UTime               uint64
+	STime               uint64
+	CUTime              int64
+	CSTime              int64
+	Priority            int64
+	Nice                int64
+	NumThreads          int64
+	ItRealValue         int64
+	StartTime           uint64
+	VSize               uint64
+	RSS                 int64
+	RSSLim              uint64
+	StartCode           uint64
+	EndCode             uint64
+	StartStack          uint64
+	KStkESP             uint64
+	KStkEIP             uint64
+	Signal              uint64
+	Blocked             uint64
+	SigIgnore           uint64
+	SigCatch            uint64
+	WChan               uint64
+	NSwap               uint64
+	CNSwap              uint64
+	ExitSignal          int
+	Processor           int
+	RTPriority          uint64
+	Policy              uint64
+	DelayAcctBlkioTicks uint64
+	GuestTime           uint64
+	CGuestTime          int64
+	StartData           uint64
+	EndData             uint64
+	StartBrk            uint64
+	ArgStart            uint64
+	ArgEnd              uint64
+	EnvStart            uint64
+	EnvEnd              uint64
+	ExitCode            int
+}
+
+// statFields walks the space separated fields after comm, remembering
+// the first error so that callers can check once at the end.
+type statFields struct {
+	fields []string
+	err    error
+}
+
+func (f *statFields) next() string {
+	if f.err != nil {
+		return "0"
+	}
+	if len(f.fields) == 0 {
+		f.err = fmt.Errorf("Not enough fields")
+		return "0"
+	}
+	field := f.fields[0]
+	f.fields = f.fields[1:]
+	return field
+}
+
+func (f *statFields) uint() uint64 {
+	value, err := strconv.ParseUint(f.next(), 10, 64)
+	if err != nil && f.err == nil {
+		f.err = err
+	}
+	return value
+}
+
+func (f *statFields) int() int64 {
+	value, err := strconv.ParseInt(f.next(), 10, 64)
+	if err != nil && f.err == nil {
+		f.err = err
+	}
+	return value
+}
+
+// small parses a field which fits in 32 bits, as an int.
+func (f *statFields) small() int {
+	value, err := strconv.ParseInt(f.next(), 10, 32)
+	if err != nil && f.err == nil {
+		f.err = err
+	}
+	return int(value)
+}
+
+// ParseProcStat parses the contents of /proc/<pid>/stat or
+// /proc/<pid>/task/<tid>/stat.  As comm may contain anything,
+// including spaces and parentheses, it runs from the first opening
+// parenthesis to the last closing one.
+func ParseProcStat(in io.Reader) (*ProcStat, error) {
+	contents, err := ioutil.ReadAll(in)
+	if err != nil {
+		return nil, err
+	}
+	text := string(contents)
+	start := strings.IndexByte(text, '(')
+	end := strings.LastIndexByte(text, ')')
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("No comm field in %q", text)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(text[:start]))
+	if err != nil {
+		return nil, err
+	}
+	fields := statFields{fields: strings.Fields(text[end+1:])}
+	state := fields.next()
+	if len(state) != 1 {
+		return nil, fmt.Errorf("Bad state %q", state)
+	}
+	s := &ProcStat{
+		PID:                 pid,
+		Comm:                text[start+1 : end],
+		State:               state[0],
+		PPID:                fields.small(),
+		PGrp:                fields.small(),
+		Session:             fields.small(),
+		TTYNr:               fields.small(),
+		TPGid:               fields.small(),
+		Flags:               fields.uint(),
+		MinFlt:              fields.uint(),
+		CMinFlt:             fields.uint(),
+		MajFlt:              fields.uint(),
+		CMajFlt:             fields.uint(),
+		UTime:               fields.uint(),
+		STime:               fields.uint(),
+		CUTime:              fields.int(),
+		CSTime:              fields.int(),
+		Priority:            fields.int(),
+		Nice:                fields.int(),
+		NumThreads:          fields.int(),
+		ItRealValue:         fields.int(),
+		StartTime:           fields.uint(),
+		VSize:               fields.uint(),
+		RSS:                 fields.int(),
+		RSSLim:              fields.uint(),
+		StartCode:           fields.uint(),
+		EndCode:             fields.uint(),
+		StartStack:          fields.uint(),
+		KStkESP:             fields.uint(),
+		KStkEIP:             fields.uint(),
+		Signal:              fields.uint(),
+		Blocked:             fields.uint(),
+		SigIgnore:           fields.uint(),
+		SigCatch:            fields.uint(),
+		WChan:               fields.uint(),
+		NSwap:               fields.uint(),
+		CNSwap:              fields.uint(),
+		ExitSignal:          fields.small(),
+		Processor:           fields.small(),
+		RTPriority:          fields.uint(),
+		Policy:              fields.uint(),
+		DelayAcctBlkioTicks: fields.uint(),
+		GuestTime:           fields.uint(),
+		CGuestTime:          fields.int(),
+	}
+	// Linux 3.3 added start_data, end_data and start_brk, and 3.5 the
+	// rest, so each is only read if the kernel wrote it.
+	for _, field := range []*uint64{&s.StartData, &s.EndData, &s.StartBrk, &s.ArgStart, &s.ArgEnd, &s.EnvStart, &s.EnvEnd} {
+		if fields.err != nil || len(fields.fields) == 0 {
+			break
+		}
+		*field = fields.uint()
+	}
+	if fields.err == nil && len(fields.fields) > 0 {
+		s.ExitCode = fields.small()
+	}
+	if fields.err != nil {
+		return nil, fields.err
+	}
+	return s, nil
+}
+
+func (s *ProcStat) point() point {
+	return point{s.UTime, s.STime, s.StartTime}
+}
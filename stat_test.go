@@ -0,0 +1,124 @@
+package procmon
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+const shStat = `1735 (sh) S 1734 1735 1735 34816 2679 4218880 655 3141 0 0 152 189 162 199 20 0 1 0 182865 12144640 534 18446744073709551615 4194304 4729572 140730058798800 140730058796792 139881841869352 0 0 2637828 2 0 0 0 17 3 0 0 7 11 13 6826728 6830659 16642048 140730058800890 140730058800894 140730058800894 140730058801136 0
+`
+
+func TestProcStat(t *testing.T) {
+	stat, err := ParseProcStat(strings.NewReader(shStat))
+	if assert.NoError(t, err) {
+		assert.Equal(t, &ProcStat{
+			PID:                 1735,
+			Comm:                "sh",
+			State:               'S',
+			PPID:                1734,
+			PGrp:                1735,
+			Session:             1735,
+			TTYNr:               34816,
+			TPGid:               2679,
+			Flags:               4218880,
+			MinFlt:              655,
+			CMinFlt:             3141,
+			UTime:               152,
+			STime:               189,
+			CUTime:              162,
+			CSTime:              199,
+			Priority:            20,
+			NumThreads:          1,
+			StartTime:           182865,
+			VSize:               12144640,
+			RSS:                 534,
+			RSSLim:              18446744073709551615,
+			StartCode:           4194304,
+			EndCode:             4729572,
+			StartStack:          140730058798800,
+			KStkESP:             140730058796792,
+			KStkEIP:             139881841869352,
+			SigIgnore:           2637828,
+			SigCatch:            2,
+			ExitSignal:          17,
+			Processor:           3,
+			DelayAcctBlkioTicks: 7,
+			GuestTime:           11,
+			CGuestTime:          13,
+			StartData:           6826728,
+			EndData:             6830659,
+			StartBrk:            16642048,
+			ArgStart:            140730058800890,
+			ArgEnd:              140730058800894,
+			EnvStart:            140730058800894,
+			EnvEnd:              140730058801136,
+		}, stat)
+	}
+}
+
+func TestProcStatAwkwardComm(t *testing.T) {
+	for _, comm := range []string{"Web Content", "tmux: server", "a) S 1 2 (b", ")", "", "(sd-pam)"} {
+		stat, err := ParseProcStat(strings.NewReader(strings.Replace(shStat, "(sh)", "("+comm+")", 1)))
+		if assert.NoError(t, err, comm) {
+			assert.Equal(t, comm, stat.Comm)
+			assert.Equal(t, 1734, stat.PPID)
+			assert.Equal(t, uint64(152), stat.UTime)
+			assert.Equal(t, uint64(189), stat.STime)
+			assert.Equal(t, uint64(182865), stat.StartTime)
+		}
+	}
+}
+
+func TestProcStatOldKernel(t *testing.T) {
+	// 2.6.32 stops after cguest_time.
+	stat, err := ParseProcStat(strings.NewReader(`1735 (sh) S 1734 1735 1735 34816 2679 4218880 655 3141 0 0 152 189 162 199 20 0 1 0 182865 12144640 534 18446744073709551615 4194304 4729572 140730058798800 140730058796792 139881841869352 0 0 2637828 2 0 0 0 17 3 0 0 7 11 13`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(13), stat.CGuestTime)
+		assert.Equal(t, uint64(0), stat.StartData)
+	}
+}
+
+func TestProcStatLinux33(t *testing.T) {
+	// 3.3 and 3.4 add start_data, end_data and start_brk.
+	stat, err := ParseProcStat(strings.NewReader(`1735 (sh) S 1734 1735 1735 34816 2679 4218880 655 3141 0 0 152 189 162 199 20 0 1 0 182865 12144640 534 18446744073709551615 4194304 4729572 140730058798800 140730058796792 139881841869352 0 0 2637828 2 0 0 0 17 3 0 0 7 11 13 6826728 6830659 16642048`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint64(6826728), stat.StartData)
+		assert.Equal(t, uint64(16642048), stat.StartBrk)
+		assert.Equal(t, uint64(0), stat.ArgStart)
+		assert.Equal(t, 0, stat.ExitCode)
+	}
+}
+
+func TestBrokenProcStat(t *testing.T) {
+	for _, input := range []string{
+		``,
+		`1735 (sh) S 1734 1735 1735`,
+		`1735 sh S 1734 1735 1735`,
+		`(sh) S 1734 1735 1735`,
+		`1735 (sh) Sleeping 1734`,
+		strings.Replace(shStat, " 152 ", " -152 ", 1),
+		strings.Replace(shStat, " 189 ", " frob ", 1),
+		strings.Replace(shStat, " 6826728 ", " start ", 1),
+	} {
+		_, err := ParseProcStat(strings.NewReader(input))
+		assert.Error(t, err, input)
+	}
+}
+
+func FuzzParseProcStat(f *testing.F) {
+	f.Add(shStat)
+	f.Add(strings.Replace(shStat, "(sh)", "(Web Content)", 1))
+	f.Add(`1735 (sh) S 1734`)
+	f.Fuzz(func(t *testing.T, input string) {
+		stat, err := ParseProcStat(strings.NewReader(input))
+		if err != nil {
+			return
+		}
+		// whatever comm was, it must have come from between the
+		// outermost parentheses.
+		if !strings.Contains(input, "("+stat.Comm+")") {
+			t.Errorf("comm %q not found in %q", stat.Comm, input)
+		}
+	})
+}
@@ -0,0 +1,158 @@
+// Package statsd ships procmon measures to a Datadog agent using the
+// DogStatsD wire format over UDP.
+package statsd
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/meteor/procmon"
+	"github.com/meteor/procmon/ecu"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DefaultAddr is where the Datadog agent listens for DogStatsD
+// traffic unless configured otherwise.
+const DefaultAddr = "127.0.0.1:8125"
+
+// Client sends gauges to a DogStatsD agent.  Every metric name is
+// prefixed with Prefix and every metric carries Tags.
+type Client struct {
+	Prefix string
+	Tags   []string
+	conn   net.Conn
+}
+
+// New creates a client that sends to the agent at addr.  As UDP is
+// connectionless, this will not fail if nothing is listening.
+func New(addr string, prefix string, tags ...string) (*Client, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{prefix, tags, conn}, nil
+}
+
+// Close releases the underlying socket.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Gauge sends a single gauge, with tags in addition to the client's
+// own.
+func (c *Client) Gauge(name string, value float64, tags ...string) error {
+	var buf bytes.Buffer
+	c.writeGauge(&buf, name, value, tags)
+	_, err := c.conn.Write(buf.Bytes())
+	return err
+}
+
+func (c *Client) writeGauge(buf *bytes.Buffer, name string, value float64, tags []string) {
+	if buf.Len() > 0 {
+		buf.WriteByte('\n')
+	}
+	buf.WriteString(c.Prefix)
+	buf.WriteString(name)
+	buf.WriteByte(':')
+	buf.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	buf.WriteString("|g")
+	if len(c.Tags)+len(tags) > 0 {
+		buf.WriteString("|#")
+		buf.WriteString(strings.Join(append(append([]string{}, c.Tags...), tags...), ","))
+	}
+}
+
+// Report sends the gauges derived from a single measure in one
+// datagram.  ECU gauges are only sent if instance is known.
+func (c *Client) Report(m *procmon.Measure, instance *ecu.Instance, tags ...string) error {
+	type gauge struct {
+		name  string
+		value float64
+	}
+	gauges := []gauge{
+		{"cpu.user", m.UserPerc()},
+		{"cpu.system", m.SysPerc()},
+		{"cpu.idle", m.IdlePerc()},
+		{"cpu.iowait", m.IOWaitPerc()},
+		{"cpu.steal", m.StealPerc()},
+		{"cpu.processor_busy", m.ProcessorBusyPerc()},
+		{"cpu.user_ecu", m.UserInECU(instance)},
+		{"cpu.system_ecu", m.SysInECU(instance)},
+		{"cpu.quota", m.QuotaPerc()},
+		{"cpu.throttled", m.ThrottledPerc()},
+		{"memory.rss_kb", float64(m.Memory)},
+		{"io.read_rate", m.ReadRate()},
+		{"io.write_rate", m.WriteRate()},
+	}
+	if h := m.Host; h != nil {
+		gauges = append(gauges,
+			gauge{"memory.host_perc", m.MemoryPerc()},
+			gauge{"host.memory.available", float64(h.Memory.Available)},
+			gauge{"host.memory.dirty", float64(h.Memory.Dirty)},
+			gauge{"host.swap.used", float64(h.Memory.SwapTotal - h.Memory.SwapFree)},
+			gauge{"host.load.1", h.Load.Load1},
+			gauge{"host.major_faults", float64(h.MajorFaults)},
+			gauge{"host.oom_kills", float64(h.OOMKills)},
+		)
+	}
+	if p := m.Pressure; p != nil {
+		gauges = append(gauges,
+			gauge{"pressure.cpu.some", p.CPU.Some.Avg10},
+			gauge{"pressure.memory.some", p.Memory.Some.Avg10},
+			gauge{"pressure.memory.full", p.Memory.Full.Avg10},
+			gauge{"pressure.io.some", p.IO.Some.Avg10},
+			gauge{"pressure.io.full", p.IO.Full.Avg10},
+		)
+	}
+	var buf bytes.Buffer
+	for _, g := range gauges {
+		// DogStatsD has no way to say "unknown"; NaN and Inf come
+		// from a missing instance or an empty interval.
+		if math.IsNaN(g.value) || math.IsInf(g.value, 0) {
+			continue
+		}
+		c.writeGauge(&buf, g.name, g.value, tags)
+	}
+	if buf.Len() == 0 {
+		return fmt.Errorf("No reportable gauges in measure")
+	}
+	_, err := c.conn.Write(buf.Bytes())
+	return err
+}
+
+// exitCauses are the values of the cause tag ReportExit sends.
+var exitCauses = map[procmon.ExitCause]string{
+	procmon.Exited:     "exited",
+	procmon.OOMKilled:  "oom_killed",
+	procmon.Segfaulted: "segfaulted",
+}
+
+// ReportExit sends an "exit" gauge of 1, tagged with how the process
+// went away, and for an OOM kill, its resident memory in kB when it
+// was killed.
+func (c *Client) ReportExit(exit *procmon.Exit, tags ...string) error {
+	var buf bytes.Buffer
+	tags = append(tags[:len(tags):len(tags)], "cause:"+exitCauses[exit.Cause])
+	c.writeGauge(&buf, "exit", 1, tags)
+	if exit.Cause == procmon.OOMKilled {
+		c.writeGauge(&buf, "exit.rss_kb", float64(exit.Event.RSS()/1024), tags)
+	}
+	_, err := c.conn.Write(buf.Bytes())
+	return err
+}
+
+// Forward reports every measure received on in until it is closed,
+// tagging each with its process as pid:<PID>, so that measures of
+// several processes are kept apart.  Errors are logged and otherwise
+// ignored, as a lost datagram is no reason to stop reporting.
+func (c *Client) Forward(in <-chan procmon.Measure, instance *ecu.Instance, tags ...string) {
+	for m := range in {
+		pid := fmt.Sprintf("pid:%d", m.PID)
+		if err := c.Report(&m, instance, append(tags[:len(tags):len(tags)], pid)...); err != nil {
+			log.WithError(err).Warn("couldn't send measure to statsd")
+		}
+	}
+}
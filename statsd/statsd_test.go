@@ -0,0 +1,162 @@
+package statsd
+
+import (
+	"github.com/meteor/procmon"
+	"github.com/meteor/procmon/dmesg"
+	"github.com/meteor/procmon/ecu"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T) (net.PacketConn, *Client) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := New(conn.LocalAddr().String(), "procmon.", "service:test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, client
+}
+
+func receive(t *testing.T, conn net.PacketConn) []string {
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(string(buf[:n]), "\n")
+}
+
+func TestGauge(t *testing.T) {
+	conn, client := listen(t)
+	defer conn.Close()
+	defer client.Close()
+	if assert.NoError(t, client.Gauge("answer", 42, "pid:1")) {
+		assert.Equal(t, []string{"procmon.answer:42|g|#service:test,pid:1"}, receive(t, conn))
+	}
+}
+
+func TestReport(t *testing.T) {
+	conn, client := listen(t)
+	defer conn.Close()
+	defer client.Close()
+	m := procmon.Measure{User: 10, System: 5, UserTotal: 40, SystemTotal: 20, IdleTotal: 40, Memory: 2048}
+	instance, _ := ecu.LookupName("c4.large")
+	if assert.NoError(t, client.Report(&m, instance)) {
+		assert.Equal(t, []string{
+			"procmon.cpu.user:10|g|#service:test",
+			"procmon.cpu.system:5|g|#service:test",
+			"procmon.cpu.idle:40|g|#service:test",
+			"procmon.cpu.iowait:0|g|#service:test",
+			"procmon.cpu.steal:0|g|#service:test",
+			"procmon.cpu.user_ecu:0.8|g|#service:test",
+			"procmon.cpu.system_ecu:0.4|g|#service:test",
+			"procmon.memory.rss_kb:2048|g|#service:test",
+		}, receive(t, conn))
+	}
+}
+
+func TestReportWithoutInstance(t *testing.T) {
+	conn, client := listen(t)
+	defer conn.Close()
+	defer client.Close()
+	m := procmon.Measure{User: 10, System: 5, UserTotal: 40, SystemTotal: 20, IdleTotal: 40, Memory: 2048}
+	if assert.NoError(t, client.Report(&m, nil)) {
+		assert.Equal(t, []string{
+			"procmon.cpu.user:10|g|#service:test",
+			"procmon.cpu.system:5|g|#service:test",
+			"procmon.cpu.idle:40|g|#service:test",
+			"procmon.cpu.iowait:0|g|#service:test",
+			"procmon.cpu.steal:0|g|#service:test",
+			"procmon.memory.rss_kb:2048|g|#service:test",
+		}, receive(t, conn))
+	}
+}
+
+func TestForward(t *testing.T) {
+	conn, client := listen(t)
+	defer conn.Close()
+	defer client.Close()
+	// as Follow sends them, from several processes
+	in := make(chan procmon.Measure, 2)
+	in <- procmon.Measure{PID: 3, UserTotal: 1, Memory: 7}
+	in <- procmon.Measure{PID: 4, UserTotal: 1, Memory: 9}
+	close(in)
+	client.Forward(in, nil, "unit:web")
+	assert.Contains(t, receive(t, conn), "procmon.memory.rss_kb:7|g|#service:test,unit:web,pid:3")
+	assert.Contains(t, receive(t, conn), "procmon.memory.rss_kb:9|g|#service:test,unit:web,pid:4")
+}
+
+func TestReportIO(t *testing.T) {
+	conn, client := listen(t)
+	defer conn.Close()
+	defer client.Close()
+	m := procmon.Measure{UserTotal: 1, IO: &procmon.IOStats{ReadBytes: 4096, WriteBytes: 512}, Elapsed: 2 * time.Second}
+	if assert.NoError(t, client.Report(&m, nil)) {
+		lines := receive(t, conn)
+		assert.Contains(t, lines, "procmon.io.read_rate:2048|g|#service:test")
+		assert.Contains(t, lines, "procmon.io.write_rate:256|g|#service:test")
+	}
+}
+
+func TestReportPressure(t *testing.T) {
+	conn, client := listen(t)
+	defer conn.Close()
+	defer client.Close()
+	m := procmon.Measure{UserTotal: 1, Pressure: &procmon.PressureStats{
+		CPU:    procmon.Pressure{Some: procmon.PressureLine{Avg10: 12.5}},
+		Memory: procmon.Pressure{Some: procmon.PressureLine{Avg10: 3}, Full: procmon.PressureLine{Avg10: 1}},
+	}}
+	if assert.NoError(t, client.Report(&m, nil)) {
+		lines := receive(t, conn)
+		assert.Contains(t, lines, "procmon.pressure.cpu.some:12.5|g|#service:test")
+		assert.Contains(t, lines, "procmon.pressure.memory.some:3|g|#service:test")
+		assert.Contains(t, lines, "procmon.pressure.memory.full:1|g|#service:test")
+		assert.Contains(t, lines, "procmon.pressure.io.full:0|g|#service:test")
+	}
+}
+
+func TestReportHost(t *testing.T) {
+	conn, client := listen(t)
+	defer conn.Close()
+	defer client.Close()
+	m := procmon.Measure{
+		UserTotal:   1,
+		MemoryStats: procmon.MemoryStats{RSS: 1 << 30},
+		Host: &procmon.HostStats{
+			Memory:   procmon.HostMemory{Total: 4 << 30, Available: 1 << 30, SwapTotal: 2048, SwapFree: 1024},
+			Load:     procmon.LoadAvg{Load1: 1.5},
+			OOMKills: 1,
+		},
+	}
+	if assert.NoError(t, client.Report(&m, nil)) {
+		lines := receive(t, conn)
+		assert.Contains(t, lines, "procmon.memory.host_perc:25|g|#service:test")
+		assert.Contains(t, lines, "procmon.host.memory.available:1073741824|g|#service:test")
+		assert.Contains(t, lines, "procmon.host.swap.used:1024|g|#service:test")
+		assert.Contains(t, lines, "procmon.host.load.1:1.5|g|#service:test")
+		assert.Contains(t, lines, "procmon.host.oom_kills:1|g|#service:test")
+	}
+}
+
+func TestReportExit(t *testing.T) {
+	conn, client := listen(t)
+	defer conn.Close()
+	defer client.Close()
+	if assert.NoError(t, client.ReportExit(&procmon.Exit{Cause: procmon.Exited}, "pid:3")) {
+		assert.Equal(t, []string{"procmon.exit:1|g|#service:test,pid:3,cause:exited"}, receive(t, conn))
+	}
+	oom := &procmon.Exit{Cause: procmon.OOMKilled, Event: &dmesg.Event{Kind: dmesg.OOMKill, PID: 3, AnonRSS: 2048 * 1024, FileRSS: 1024}}
+	if assert.NoError(t, client.ReportExit(oom, "pid:3")) {
+		assert.Equal(t, []string{
+			"procmon.exit:1|g|#service:test,pid:3,cause:oom_killed",
+			"procmon.exit.rss_kb:2049|g|#service:test,pid:3,cause:oom_killed",
+		}, receive(t, conn))
+	}
+}
@@ -0,0 +1,167 @@
+package procmon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sync"
+)
+
+// ErrNoMatch is returned by a Selector when no process matches.
+var ErrNoMatch = errors.New("No matching process")
+
+// Selector finds processes to monitor by something more durable than
+// their pid, so that they can be found again after a restart.
+type Selector interface {
+	// Select returns the pids of all matching processes in the
+	// procfs proc, in no particular order, or ErrNoMatch.
+	Select(proc fs.FS) ([]int, error)
+	// String describes the selector for logging.
+	String() string
+}
+
+type commSelector string
+
+// ByComm selects processes whose name, as shown by ps -o comm, is
+// exactly comm.  The kernel truncates names to 15 characters.
+func ByComm(comm string) Selector {
+	return commSelector(comm)
+}
+
+func (s commSelector) String() string {
+	return fmt.Sprintf("comm %q", string(s))
+}
+
+type cmdlineSelector struct {
+	pattern *regexp.Regexp
+}
+
+// ByCmdline selects processes whose command line, with arguments
+// separated by spaces, matches pattern.  The calling process is never
+// selected.
+func ByCmdline(pattern *regexp.Regexp) Selector {
+	return cmdlineSelector{pattern}
+}
+
+func (s cmdlineSelector) String() string {
+	return fmt.Sprintf("cmdline matching %q", s.pattern)
+}
+
+type pidfileSelector string
+
+// ByPidfile selects the process whose pid is written in the file at
+// path, provided it is still running.
+func ByPidfile(path string) Selector {
+	return pidfileSelector(path)
+}
+
+func (s pidfileSelector) String() string {
+	return fmt.Sprintf("pidfile %s", string(s))
+}
+
+type unitSelector string
+
+// BySystemdUnit selects the processes in the cgroup of a systemd unit,
+// such as "nginx.service".  Follow monitors each of them.
+func BySystemdUnit(unit string) Selector {
+	return unitSelector(unit)
+}
+
+func (s unitSelector) String() string {
+	return fmt.Sprintf("systemd unit %s", string(s))
+}
+
+// followed is a process Follow was monitoring, and why it stopped.
+type followed struct {
+	pid int
+	err error
+}
+
+// Follow monitors every process sel selects, sending all their
+// measures to out, where PID tells them apart.  It selects again every
+// opts.Interval, and as soon as a process exits or is replaced, so
+// that processes which start matching later, such as a restarted
+// service, are picked up, and tells opts.OnExit, if set, how each one
+// that exits went away.  It blocks until ctx is done or monitoring
+// some process fails for a reason other than it going away, then
+// stops every monitor, closes out and returns why.
+func Follow(ctx context.Context, out chan<- Measure, sel Selector, opts Options) error {
+	defer close(out)
+	opts, err := opts.withDefaults()
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	logger := opts.Logger.WithField("selector", sel.String())
+	ended := make(chan followed)
+	running := make(map[int]bool)
+	for {
+		pids, err := sel.Select(opts.Proc)
+		if err == ErrNoMatch {
+			logger.Debug("no matching process")
+		} else if err != nil {
+			logger.WithError(err).Warn("couldn't select process")
+		}
+		for _, pid := range pids {
+			if running[pid] {
+				continue
+			}
+			in := make(chan Measure, 1)
+			m, err := NewWithContext(ctx, in, pid, opts)
+			if err != nil {
+				// it may have exited since it was selected
+				if _, statErr := fetchProcessStat(opts.Proc, pid); statErr == nil {
+					logger.WithField("process", pid).WithError(err).Warn("couldn't monitor process")
+				}
+				continue
+			}
+			logger.WithField("process", pid).Info("following process")
+			running[pid] = true
+			wg.Add(1)
+			go func(pid int) {
+				defer wg.Done()
+				err := forward(ctx, out, in, m)
+				if err == ErrProcessExited && opts.OnExit != nil {
+					opts.OnExit(pid, m.Exit())
+				}
+				select {
+				case ended <- followed{pid, err}:
+				case <-ctx.Done():
+				}
+			}(pid)
+		}
+		wait := opts.Clock.After(opts.Interval)
+	waiting:
+		for {
+			select {
+			case f := <-ended:
+				delete(running, f.pid)
+				if f.err != ErrProcessExited && f.err != ErrProcessReplaced {
+					return f.err
+				}
+				break waiting
+			case <-wait:
+				break waiting
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// forward copies the measures m sends on in to out until it stops.
+func forward(ctx context.Context, out chan<- Measure, in <-chan Measure, m *Monitor) error {
+	for measure := range in {
+		select {
+		case out <- measure:
+		case <-ctx.Done():
+			m.Stop()
+		}
+	}
+	return m.Wait()
+}
@@ -0,0 +1,142 @@
+// +build linux
+
+package procmon
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// allPids lists every process in proc.
+func allPids(proc fs.FS) ([]int, error) {
+	entries, err := fs.ReadDir(proc, ".")
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// matching returns the pids for which match says yes given the
+// contents of <pid>/<file> in proc.  Processes which exit while being
+// looked at are ignored.
+func matching(proc fs.FS, file string, match func(contents []byte) bool) ([]int, error) {
+	pids, err := allPids(proc)
+	if err != nil {
+		return nil, err
+	}
+	var result []int
+	for _, pid := range pids {
+		contents, err := fs.ReadFile(proc, fmt.Sprintf("%d/%s", pid, file))
+		if err != nil {
+			continue
+		}
+		if match(contents) {
+			result = append(result, pid)
+		}
+	}
+	if len(result) == 0 {
+		return nil, ErrNoMatch
+	}
+	return result, nil
+}
+
+func (s commSelector) Select(proc fs.FS) ([]int, error) {
+	return matching(proc, "comm", func(contents []byte) bool {
+		return strings.TrimSuffix(string(contents), "\n") == string(s)
+	})
+}
+
+// parseCmdline turns the NUL separated arguments of
+// /proc/<pid>/cmdline into something like a shell command line.
+func parseCmdline(contents []byte) string {
+	return string(bytes.Replace(bytes.TrimRight(contents, "\x00"), []byte{0}, []byte{' '}, -1))
+}
+
+func (s cmdlineSelector) Select(proc fs.FS) ([]int, error) {
+	self := os.Getpid()
+	pids, err := matching(proc, "cmdline", func(contents []byte) bool {
+		// kernel threads have no command line
+		return len(contents) > 0 && s.pattern.MatchString(parseCmdline(contents))
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, pid := range pids {
+		if pid == self {
+			pids = append(pids[:i], pids[i+1:]...)
+			break
+		}
+	}
+	if len(pids) == 0 {
+		return nil, ErrNoMatch
+	}
+	return pids, nil
+}
+
+func parsePidfile(in io.Reader) (int, error) {
+	contents, err := ioutil.ReadAll(in)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(contents)))
+}
+
+func (s pidfileSelector) Select(proc fs.FS) ([]int, error) {
+	file, err := os.Open(string(s))
+	if os.IsNotExist(err) {
+		return nil, ErrNoMatch
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	pid, err := parsePidfile(file)
+	if err != nil {
+		// it may be half written by a restarting service
+		return nil, ErrNoMatch
+	}
+	if _, err := fs.Stat(proc, strconv.Itoa(pid)); err != nil {
+		return nil, ErrNoMatch
+	}
+	return []int{pid}, nil
+}
+
+// inUnit reports whether any of the hierarchies in /proc/<pid>/cgroup
+// place the process in unit.  Per cgroups(7) each line is
+// "hierarchy-ID:controllers:path", and systemd names the cgroup after
+// the unit, nested inside its slice.
+func inUnit(in io.Reader, unit string) bool {
+	s := bufio.NewScanner(in)
+	for s.Scan() {
+		parts := strings.SplitN(s.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, part := range strings.Split(parts[2], "/") {
+			if part == unit {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s unitSelector) Select(proc fs.FS) ([]int, error) {
+	return matching(proc, "cgroup", func(contents []byte) bool {
+		return inUnit(bytes.NewReader(contents), string(s))
+	})
+}
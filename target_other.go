@@ -0,0 +1,21 @@
+// +build !linux
+
+package procmon
+
+import "io/fs"
+
+func (s commSelector) Select(proc fs.FS) ([]int, error) {
+	return nil, errNotSupported
+}
+
+func (s cmdlineSelector) Select(proc fs.FS) ([]int, error) {
+	return nil, errNotSupported
+}
+
+func (s pidfileSelector) Select(proc fs.FS) ([]int, error) {
+	return nil, errNotSupported
+}
+
+func (s unitSelector) Select(proc fs.FS) ([]int, error) {
+	return nil, errNotSupported
+}
@@ -0,0 +1,190 @@
+// +build linux
+
+package procmon
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestCmdline(t *testing.T) {
+	assert.Equal(t, "node server.js --port 80", parseCmdline([]byte("node\x00server.js\x00--port\x0080\x00")))
+	assert.Equal(t, "", parseCmdline([]byte("")))
+}
+
+func TestPidfile(t *testing.T) {
+	pid, err := parsePidfile(strings.NewReader("1735\n"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, 1735, pid)
+	}
+	_, err = parsePidfile(strings.NewReader(""))
+	assert.Error(t, err)
+}
+
+func TestInUnit(t *testing.T) {
+	v1 := `12:pids:/system.slice/nginx.service
+2:cpu,cpuacct:/system.slice/nginx.service
+1:name=systemd:/system.slice/nginx.service
+`
+	assert.True(t, inUnit(strings.NewReader(v1), "nginx.service"))
+	assert.False(t, inUnit(strings.NewReader(v1), "nginx"))
+	v2 := `0::/user.slice/user-1000.slice/session-2.scope
+`
+	assert.True(t, inUnit(strings.NewReader(v2), "session-2.scope"))
+	assert.False(t, inUnit(strings.NewReader(v2), "nginx.service"))
+}
+
+func startSleep(t *testing.T) *exec.Cmd {
+	cmd := exec.Command("sleep", "1000")
+	if err := cmd.Start(); err != nil {
+		t.Skip("can't start child:", err)
+	}
+	return cmd
+}
+
+func TestSelectors(t *testing.T) {
+	cmd := startSleep(t)
+	defer cmd.Process.Kill()
+	pids, err := ByComm("sleep").Select(defaultProc)
+	if assert.NoError(t, err) {
+		assert.Contains(t, pids, cmd.Process.Pid)
+	}
+	pids, err = ByCmdline(regexp.MustCompile(`^sleep 1000$`)).Select(defaultProc)
+	if assert.NoError(t, err) {
+		assert.Contains(t, pids, cmd.Process.Pid)
+	}
+	_, err = ByCmdline(regexp.MustCompile(`no process has this \d+ command line`)).Select(defaultProc)
+	assert.Equal(t, ErrNoMatch, err)
+	_, err = ByPidfile("/nonexistent/procmon.pid").Select(defaultProc)
+	assert.Equal(t, ErrNoMatch, err)
+}
+
+func TestSelectorsProc(t *testing.T) {
+	// as a container would see its host's procfs
+	f := newProcFixture()
+	f.set("100/comm", "nginx\n")
+	f.set("100/cmdline", "nginx: master process\x00")
+	f.set("100/cgroup", "0::/system.slice/nginx.service\n")
+	f.set("101/comm", "nginx\n")
+	f.set("101/cmdline", "nginx: worker process\x00")
+	f.set("101/cgroup", "0::/system.slice/nginx.service\n")
+	proc := fstest.MapFS(f)
+	pids, err := ByComm("nginx").Select(proc)
+	if assert.NoError(t, err) {
+		assert.ElementsMatch(t, []int{100, 101}, pids)
+	}
+	pids, err = ByCmdline(regexp.MustCompile(`worker`)).Select(proc)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []int{101}, pids)
+	}
+	pids, err = BySystemdUnit("nginx.service").Select(proc)
+	if assert.NoError(t, err) {
+		assert.ElementsMatch(t, []int{100, 101}, pids)
+	}
+	_, err = ByComm("sleep").Select(proc)
+	assert.Equal(t, ErrNoMatch, err)
+
+	dir, err := ioutil.TempDir("", "procmon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidfile := filepath.Join(dir, "nginx.pid")
+	if err := ioutil.WriteFile(pidfile, []byte("100\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pids, err = ByPidfile(pidfile).Select(proc)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []int{100}, pids)
+	}
+	f.remove(100)
+	_, err = ByPidfile(pidfile).Select(proc)
+	assert.Equal(t, ErrNoMatch, err)
+}
+
+func TestFollow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "procmon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidfile := filepath.Join(dir, "sleep.pid")
+	write := func(pid int) {
+		if err := ioutil.WriteFile(pidfile, []byte(fmt.Sprintln(pid)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first := startSleep(t)
+	write(first.Process.Pid)
+	ctx, cancel := context.WithCancel(context.Background())
+	out := make(chan Measure)
+	result := make(chan error)
+	exited := make(chan int, 1)
+	opts := Options{Interval: 5 * time.Millisecond, Policy: BlockWhenFull}
+	opts.OnExit = func(pid int, exit *Exit) {
+		assert.Equal(t, &Exit{Cause: Exited}, exit)
+		exited <- pid
+	}
+	go func() {
+		result <- Follow(ctx, out, ByPidfile(pidfile), opts)
+	}()
+	assert.Equal(t, first.Process.Pid, (<-out).PID)
+
+	second := startSleep(t)
+	defer second.Process.Kill()
+	write(second.Process.Pid)
+	first.Process.Kill()
+	first.Wait()
+	// measures keep coming from the replacement
+	for measure := range out {
+		if measure.PID == second.Process.Pid {
+			break
+		}
+	}
+	assert.Equal(t, first.Process.Pid, <-exited)
+
+	cancel()
+	for range out {
+	}
+	assert.Equal(t, context.Canceled, <-result)
+}
+
+func TestFollowEvery(t *testing.T) {
+	var pids []int
+	for i := 0; i < 2; i++ {
+		cmd := exec.Command("sleep", "1001")
+		if err := cmd.Start(); err != nil {
+			t.Skip("can't start child:", err)
+		}
+		defer cmd.Wait()
+		defer cmd.Process.Kill()
+		pids = append(pids, cmd.Process.Pid)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	out := make(chan Measure)
+	result := make(chan error)
+	go func() {
+		result <- Follow(ctx, out, ByCmdline(regexp.MustCompile(`^sleep 1001$`)), Options{Interval: 5 * time.Millisecond, Policy: BlockWhenFull})
+	}()
+	seen := make(map[int]bool)
+	for len(seen) < 2 {
+		measure := <-out
+		assert.Contains(t, pids, measure.PID)
+		seen[measure.PID] = true
+	}
+	cancel()
+	for range out {
+	}
+	assert.Equal(t, context.Canceled, <-result)
+}
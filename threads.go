@@ -0,0 +1,43 @@
+package procmon
+
+import "sort"
+
+// ThreadMeasure is the CPU consumption of a single thread of the
+// monitored process over a sampling interval.
+type ThreadMeasure struct {
+	// TID is the thread's id.
+	TID int
+	// Comm is the thread's name, as set by prctl(PR_SET_NAME).
+	Comm string
+	// User is the number of usermode CPU jiffies used
+	User uint64
+	// System is the number of kernelmode CPU jiffies used
+	System uint64
+}
+
+// Point in time measure of a thread's state
+type thread struct {
+	comm  string
+	usage point
+}
+
+// threadDelta works out what each thread in new used since old was
+// taken, ordered by tid.  As with usageDelta, new threads and reused
+// tids count from zero.
+func threadDelta(old, new map[int]thread) []ThreadMeasure {
+	result := make([]ThreadMeasure, 0, len(new))
+	for tid, now := range new {
+		then, ok := old[tid]
+		if !ok || now.usage.start != then.usage.start {
+			then = thread{}
+		}
+		result = append(result, ThreadMeasure{
+			tid,
+			now.comm,
+			now.usage.user - then.usage.user,
+			now.usage.system - then.usage.system,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].TID < result[j].TID })
+	return result
+}
@@ -0,0 +1,17 @@
+package procmon
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestThreadDelta(t *testing.T) {
+	old := map[int]thread{7: {"main", point{100, 50, 1}}, 9: {"gc", point{10, 10, 1}}, 10: {"old", point{50, 50, 1}}}
+	new := map[int]thread{9: {"gc", point{30, 12, 1}}, 7: {"main", point{101, 50, 1}}, 8: {"worker", point{5, 0, 2}}, 10: {"new", point{60, 60, 3}}}
+	assert.Equal(t, []ThreadMeasure{
+		{7, "main", 1, 0},
+		{8, "worker", 5, 0},
+		{9, "gc", 20, 2},
+		{10, "new", 60, 60},
+	}, threadDelta(old, new))
+}
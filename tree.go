@@ -0,0 +1,82 @@
+package procmon
+
+// fetchProcesses samples the monitored process, and its descendants
+// if monitoring a tree, alongside sys.  Only failure to read the
+// monitored process itself is an error; descendants may exit at any
+// moment, and I/O accounting may be hidden from us.
+func (m *Monitor) fetchProcesses(sys system) (sample, error) {
+	result := sample{
+		at:    m.clock.Now(),
+		usage: make(map[int]point),
+		io:    make(map[int]IOStats),
+	}
+	stat, err := fetchProcessStat(m.proc, m.process)
+	if err != nil {
+		return sample{}, err
+	}
+	if stat.StartTime != m.start {
+		return sample{}, ErrProcessReplaced
+	}
+	result.processor = stat.Processor
+	result.memory, err = fetchProcessMemory(m.proc, m.process, m.detail)
+	if err != nil {
+		return sample{}, err
+	}
+	result.usage[m.process] = stat.point()
+	m.fetchIO(m.process, result.io)
+	result.quota = m.fetchQuota()
+	result.pressure = sys.pressure
+	if m.psi == CgroupPressure {
+		result.pressure = m.fetchPressure()
+	}
+	result.host = sys.host
+	if !m.tree {
+		return result, nil
+	}
+	descendants, err := fetchDescendants(m.proc, m.process)
+	if err != nil {
+		return sample{}, err
+	}
+	for _, pid := range descendants {
+		stat, err := fetchProcessStat(m.proc, pid)
+		if err != nil {
+			continue
+		}
+		memory, err := fetchProcessMemory(m.proc, pid, m.detail)
+		if err != nil {
+			continue
+		}
+		result.usage[pid] = stat.point()
+		result.memory.add(memory)
+		m.fetchIO(pid, result.io)
+	}
+	return result, nil
+}
+
+func (m *Monitor) fetchIO(pid int, into map[int]IOStats) {
+	stats, err := fetchProcessIO(m.proc, pid)
+	if err != nil {
+		// reading another user's io file needs ptrace access
+		m.logger.WithField("pid", pid).WithError(err).Debug("couldn't read I/O stats")
+		return
+	}
+	into[pid] = stats
+}
+
+// usageDelta sums the CPU time used by each process between two
+// samples.  Processes that appeared since old count from zero, and
+// processes that exited are dropped along with whatever they used
+// since old was taken.  A pid which started at a different time has
+// been reused, so it too counts from zero.
+func usageDelta(old, new map[int]point) point {
+	var delta point
+	for pid, now := range new {
+		then, ok := old[pid]
+		if !ok || now.start != then.start {
+			then = point{}
+		}
+		delta.user += now.user - then.user
+		delta.system += now.system - then.system
+	}
+	return delta
+}
@@ -0,0 +1,18 @@
+package procmon
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestUsageDelta(t *testing.T) {
+	old := map[int]point{1: {100, 50, 7}, 2: {10, 10, 8}, 3: {500, 500, 9}}
+	new := map[int]point{1: {110, 55, 7}, 2: {12, 11, 8}, 4: {3, 1, 10}}
+	assert.Equal(t, point{110 - 100 + 12 - 10 + 3, 55 - 50 + 11 - 10 + 1, 0}, usageDelta(old, new))
+}
+
+func TestUsageDeltaReusedPid(t *testing.T) {
+	old := map[int]point{1: {100, 50, 7}, 2: {900, 900, 8}}
+	new := map[int]point{1: {100, 50, 7}, 2: {1000, 1000, 9}}
+	assert.Equal(t, point{1000, 1000, 0}, usageDelta(old, new))
+}
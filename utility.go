@@ -3,9 +3,12 @@ package procmon
 import "github.com/meteor/procmon/ecu"
 import "math"
 
-// Total calculates the total jiffies spent between ticks.
+// Total calculates the total jiffies spent between ticks.  Guest
+// time is already part of UserTotal, so is not counted again.
 func (m *Measure) Total() uint64 {
-	return m.UserTotal + m.SystemTotal + m.IdleTotal
+	// not CPU.total(), so that measures without a breakdown work
+	return m.UserTotal + m.SystemTotal + m.IdleTotal +
+		m.CPU.IOWait + m.CPU.IRQ + m.CPU.SoftIRQ + m.CPU.Steal
 }
 
 // UserPerc calculates the percentage of CPU time spent in userland by
@@ -19,12 +22,36 @@ func (m *Measure) IdlePerc() float64 {
 	return 100.0 * float64(m.IdleTotal) / float64(m.Total())
 }
 
+// IOWaitPerc calculates the percentage of CPU time spent idle while
+// I/O was outstanding.
+func (m *Measure) IOWaitPerc() float64 {
+	return 100.0 * float64(m.CPU.IOWait) / float64(m.Total())
+}
+
+// StealPerc calculates the percentage of CPU time stolen by the
+// hypervisor to run other virtual machines.
+func (m *Measure) StealPerc() float64 {
+	return 100.0 * float64(m.CPU.Steal) / float64(m.Total())
+}
+
 // SysPerc calculates the percentage of CPU time spent in the kernel by
 // the monitored process.
 func (m *Measure) SysPerc() float64 {
 	return 100.0 * float64(m.System) / float64(m.Total())
 }
 
+// ProcessorBusyPerc calculates how busy the CPU the monitored process
+// last ran on was, to tell whether it is competing for a saturated
+// core.  It is NaN if that CPU went offline.
+func (m *Measure) ProcessorBusyPerc() float64 {
+	for _, core := range m.Cores {
+		if core.CPU == m.Processor {
+			return core.BusyPerc()
+		}
+	}
+	return math.NaN()
+}
+
 func (m *Measure) scaleBy(datum uint64, instance *ecu.Instance) float64 {
 	return float64(instance.ComputeUnitsx10) * float64(datum) /
 		(float64(m.Total()) * 10.0)
@@ -0,0 +1,44 @@
+package procmon
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestPercentages(t *testing.T) {
+	m := Measure{
+		User:        10,
+		System:      5,
+		UserTotal:   30,
+		SystemTotal: 20,
+		IdleTotal:   40,
+		CPU:         CPUTimes{User: 25, Nice: 5, System: 20, Idle: 40, IOWait: 10, IRQ: 2, SoftIRQ: 3, Steal: 20, Guest: 4},
+	}
+	assert.Equal(t, uint64(125), m.Total())
+	assert.Equal(t, 8.0, m.UserPerc())
+	assert.Equal(t, 4.0, m.SysPerc())
+	assert.Equal(t, 32.0, m.IdlePerc())
+	assert.Equal(t, 8.0, m.IOWaitPerc())
+	assert.Equal(t, 16.0, m.StealPerc())
+}
+
+func TestCPUTimesBackwards(t *testing.T) {
+	then := CPUTimes{User: 100, System: 50, Idle: 1000, IOWait: 30}
+	now := CPUTimes{User: 110, System: 55, Idle: 1080, IOWait: 25}
+	delta := now.sub(then)
+	assert.Equal(t, CPUTimes{User: 10, System: 5, Idle: 80}, delta)
+	assert.Equal(t, uint64(95), delta.total())
+}
+
+func TestCoreDelta(t *testing.T) {
+	old := map[int]CPUTimes{0: {User: 100, Idle: 100}, 1: {User: 100, Idle: 100}, 2: {User: 500, Idle: 500, IOWait: 50}}
+	new := map[int]CPUTimes{0: {User: 190, Idle: 110}, 2: {User: 600, Idle: 520, IOWait: 40}, 3: {User: 10, Idle: 10}}
+	cores := coreDelta(old, new)
+	// iowait can go backwards, which counts as none
+	assert.Equal(t, []CoreMeasure{{0, CPUTimes{User: 90, Idle: 10}}, {2, CPUTimes{User: 100, Idle: 20}}}, cores)
+	m := Measure{Cores: cores, Processor: 0}
+	assert.Equal(t, 90.0, m.ProcessorBusyPerc())
+	m.Processor = 1
+	assert.True(t, math.IsNaN(m.ProcessorBusyPerc()))
+}